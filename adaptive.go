@@ -0,0 +1,91 @@
+package xrayhq
+
+import (
+	"sync"
+	"time"
+)
+
+// adaptiveSampler limits how many traces are stored per route and interval.
+// Each route's keep probability for an interval is derived from its traffic
+// in the previous one, and the first request of every interval is always
+// kept so quiet routes stay visible. Once traffic exceeds what that
+// probability was derived from, it falls with the square of the excess, so
+// a surge keeps at most about twice the budget. Every request keeps a
+// nonzero chance, so weighting kept traces by the probability they were
+// kept with gives unbiased estimates.
+type adaptiveSampler struct {
+	mu       sync.Mutex
+	budget   float64 // traces per interval and route
+	interval time.Duration
+	routes   map[string]*adaptiveWindow
+	now      func() time.Time
+}
+
+type adaptiveWindow struct {
+	start    time.Time
+	seen     int
+	kept     int
+	prevSeen int
+	rate     float64
+	expected float64 // requests rate was derived for
+}
+
+func newAdaptiveSampler(perSecond float64, interval time.Duration) *adaptiveSampler {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return &adaptiveSampler{
+		budget:   perSecond * interval.Seconds(),
+		interval: interval,
+		routes:   make(map[string]*adaptiveWindow),
+		now:      time.Now,
+	}
+}
+
+// sample decides whether to keep a trace for route key. It returns the keep
+// probability applied, for extrapolating counts from stored traces.
+func (s *adaptiveSampler) sample(key string) (keep bool, rate float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	w, ok := s.routes[key]
+	if !ok {
+		w = &adaptiveWindow{start: now, rate: 1, expected: s.budget}
+		s.routes[key] = w
+	}
+	if elapsed := now.Sub(w.start); elapsed >= s.interval {
+		// A route idle for more than one interval starts again from its
+		// most recent traffic rather than from stale history.
+		w.prevSeen = w.seen
+		if elapsed >= 2*s.interval {
+			w.prevSeen = 0
+		}
+		w.start, w.seen, w.kept = now, 0, 0
+		w.rate, w.expected = 1, s.budget
+		if float64(w.prevSeen) > s.budget {
+			w.rate, w.expected = s.budget/float64(w.prevSeen), float64(w.prevSeen)
+		}
+	}
+	w.seen++
+
+	if w.kept == 0 {
+		w.kept++
+		return true, 1
+	}
+	rate = w.rate
+	if excess := float64(w.seen) / w.expected; excess > 1 {
+		rate /= excess * excess
+	}
+	if keep = sampleHit(rate); keep {
+		w.kept++
+	}
+	return keep, rate
+}
+
+// forget drops the state for route key, once the route's metrics are gone.
+func (s *adaptiveSampler) forget(key string) {
+	s.mu.Lock()
+	delete(s.routes, key)
+	s.mu.Unlock()
+}
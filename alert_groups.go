@@ -0,0 +1,265 @@
+package xrayhq
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AlertState is where an alert group is in its lifecycle.
+type AlertState string
+
+const (
+	AlertFiring       AlertState = "firing"
+	AlertAcknowledged AlertState = "acknowledged"
+	AlertResolved     AlertState = "resolved"
+)
+
+// ErrAlertNotFound is returned when acknowledging or resolving an unknown
+// alert group.
+var ErrAlertNotFound = errors.New("xrayhq: alert not found")
+
+// AlertGroup collects repeated alerts with the same fingerprint: the same
+// type, route and, for query alerts, query pattern. A group fires on its
+// first alert, can be acknowledged, and is resolved when its condition
+// clears or by hand. An alert for a resolved group fires it again.
+type AlertGroup struct {
+	Fingerprint  string
+	Type         string
+	Severity     Severity
+	Method       string
+	RoutePattern string
+	Pattern      string
+
+	State          AlertState
+	FirstSeen      time.Time
+	LastSeen       time.Time
+	Count          int64
+	AcknowledgedAt time.Time
+	ResolvedAt     time.Time
+
+	// Last is the most recent occurrence.
+	Last Alert
+}
+
+// Active reports whether the group is firing or acknowledged.
+func (g *AlertGroup) Active() bool {
+	return g.State != AlertResolved
+}
+
+// alertFingerprint identifies alerts that should be grouped together.
+func alertFingerprint(alertType, method, route, pattern string) string {
+	h := fnv.New64a()
+	for _, s := range []string{alertType, method, route, pattern} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// alertGroups holds alert groups within the collector's retention limits.
+type alertGroups struct {
+	mu           sync.Mutex
+	groups       map[string]*AlertGroup
+	resolveAfter time.Duration
+	retention    time.Duration
+	maxGroups    int
+	now          func() time.Time
+	lastSweep    time.Time
+
+	// totals counts alerts by type, including those of dropped groups.
+	totals map[string]int64
+}
+
+func newAlertGroups(cfg *Config, now func() time.Time) *alertGroups {
+	return &alertGroups{
+		groups:       make(map[string]*AlertGroup),
+		totals:       make(map[string]int64),
+		resolveAfter: cfg.AlertResolveAfter,
+		retention:    cfg.AlertRetention,
+		maxGroups:    cfg.MaxAlertGroups,
+		now:          now,
+	}
+}
+
+func (ag *alertGroups) add(a Alert) {
+	ag.mu.Lock()
+	defer ag.mu.Unlock()
+
+	now := ag.now()
+	g, ok := ag.groups[a.Fingerprint]
+	if !ok {
+		g = &AlertGroup{
+			Fingerprint:  a.Fingerprint,
+			Type:         a.Type,
+			Method:       a.Method,
+			RoutePattern: a.RoutePattern,
+			Pattern:      a.Pattern,
+			FirstSeen:    now,
+		}
+		ag.groups[a.Fingerprint] = g
+	}
+	if g.State == "" || g.State == AlertResolved {
+		g.State = AlertFiring
+		g.AcknowledgedAt, g.ResolvedAt = time.Time{}, time.Time{}
+	}
+	g.Severity = a.Severity
+	g.LastSeen = now
+	g.Count++
+	g.Last = a
+	ag.totals[a.Type]++
+
+	if !ok {
+		ag.sweep(now, true)
+	}
+}
+
+// clear resolves the group for fingerprint, if active, because its
+// condition no longer holds.
+func (ag *alertGroups) clear(fingerprint string) {
+	ag.mu.Lock()
+	defer ag.mu.Unlock()
+	if g, ok := ag.groups[fingerprint]; ok && g.Active() {
+		g.State = AlertResolved
+		g.ResolvedAt = ag.now()
+	}
+}
+
+// sweep resolves groups that have been quiet for resolveAfter, drops
+// resolved groups older than retention and then, past maxGroups, the
+// groups seen least recently, resolved ones first. Unless forced, it runs
+// at most once a second. ag.mu must be held.
+func (ag *alertGroups) sweep(now time.Time, force bool) {
+	if !force && now.Sub(ag.lastSweep) < time.Second {
+		return
+	}
+	ag.lastSweep = now
+	for fp, g := range ag.groups {
+		if g.Active() && ag.resolveAfter > 0 && now.Sub(g.LastSeen) >= ag.resolveAfter {
+			g.State = AlertResolved
+			g.ResolvedAt = g.LastSeen.Add(ag.resolveAfter)
+		}
+		if g.State == AlertResolved && ag.retention > 0 && now.Sub(g.ResolvedAt) >= ag.retention {
+			delete(ag.groups, fp)
+		}
+	}
+	if ag.maxGroups <= 0 || len(ag.groups) <= ag.maxGroups {
+		return
+	}
+	order := make([]*AlertGroup, 0, len(ag.groups))
+	for _, g := range ag.groups {
+		order = append(order, g)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		if order[i].Active() != order[j].Active() {
+			return !order[i].Active()
+		}
+		return order[i].LastSeen.Before(order[j].LastSeen)
+	})
+	for _, g := range order[:len(order)-ag.maxGroups] {
+		delete(ag.groups, g.Fingerprint)
+	}
+}
+
+// list returns copies of all groups, active ones first and then by most
+// recently seen.
+func (ag *alertGroups) list() []AlertGroup {
+	ag.mu.Lock()
+	defer ag.mu.Unlock()
+	ag.sweep(ag.now(), false)
+	out := make([]AlertGroup, 0, len(ag.groups))
+	for _, g := range ag.groups {
+		out = append(out, *g)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Active() != out[j].Active() {
+			return out[i].Active()
+		}
+		return out[i].LastSeen.After(out[j].LastSeen)
+	})
+	return out
+}
+
+// typeTotals returns how many alerts of each type have fired.
+func (ag *alertGroups) typeTotals() map[string]int64 {
+	ag.mu.Lock()
+	defer ag.mu.Unlock()
+	out := make(map[string]int64, len(ag.totals))
+	for t, n := range ag.totals {
+		out[t] = n
+	}
+	return out
+}
+
+func (ag *alertGroups) get(fingerprint string) (AlertGroup, bool) {
+	ag.mu.Lock()
+	defer ag.mu.Unlock()
+	ag.sweep(ag.now(), false)
+	g, ok := ag.groups[fingerprint]
+	if !ok {
+		return AlertGroup{}, false
+	}
+	return *g, true
+}
+
+func (ag *alertGroups) setState(fingerprint string, state AlertState) (AlertGroup, error) {
+	ag.mu.Lock()
+	defer ag.mu.Unlock()
+	g, ok := ag.groups[fingerprint]
+	if !ok {
+		return AlertGroup{}, ErrAlertNotFound
+	}
+	now := ag.now()
+	switch state {
+	case AlertAcknowledged:
+		if g.State != AlertFiring {
+			return *g, fmt.Errorf("xrayhq: cannot acknowledge a %s alert", g.State)
+		}
+		g.AcknowledgedAt = now
+	case AlertResolved:
+		if g.State == AlertResolved {
+			return *g, nil
+		}
+		g.ResolvedAt = now
+	}
+	g.State = state
+	return *g, nil
+}
+
+// AlertGroups returns all retained alert groups, active ones first and then
+// by most recently seen.
+func (c *Collector) AlertGroups() []AlertGroup {
+	return c.alertGroups.list()
+}
+
+// AlertGroup returns the group with the given fingerprint.
+func (c *Collector) AlertGroup(fingerprint string) (AlertGroup, bool) {
+	return c.alertGroups.get(fingerprint)
+}
+
+// ActiveAlertCount returns how many alert groups are firing or
+// acknowledged.
+func (c *Collector) ActiveAlertCount() int {
+	n := 0
+	for _, g := range c.alertGroups.list() {
+		if g.Active() {
+			n++
+		}
+	}
+	return n
+}
+
+// AcknowledgeAlert marks a firing alert group as acknowledged. It keeps
+// counting occurrences until it is resolved.
+func (c *Collector) AcknowledgeAlert(fingerprint string) (AlertGroup, error) {
+	return c.alertGroups.setState(fingerprint, AlertAcknowledged)
+}
+
+// ResolveAlert resolves an alert group by hand. Another alert with the same
+// fingerprint fires it again.
+func (c *Collector) ResolveAlert(fingerprint string) (AlertGroup, error) {
+	return c.alertGroups.setState(fingerprint, AlertResolved)
+}
@@ -0,0 +1,57 @@
+package xrayhq
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// handleAlertAction acknowledges or resolves the alert group named by the
+// fingerprint form value. Dashboard forms are redirected back to the alerts
+// page; clients asking for JSON get the updated group.
+func (ds *DashboardServer) handleAlertAction(state AlertState) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		fingerprint := r.FormValue("fingerprint")
+
+		var group AlertGroup
+		var err error
+		if state == AlertAcknowledged {
+			group, err = ds.collector.AcknowledgeAlert(fingerprint)
+		} else {
+			group, err = ds.collector.ResolveAlert(fingerprint)
+		}
+
+		wantJSON := strings.Contains(r.Header.Get("Accept"), "application/json")
+		switch {
+		case errors.Is(err, ErrAlertNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusConflict)
+		case wantJSON:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(group)
+		default:
+			http.Redirect(w, r, ds.base+"/alerts", http.StatusSeeOther)
+		}
+	}
+}
+
+// handleAPIAlerts lists alert groups as JSON. The state parameter limits the
+// list to one state.
+func (ds *DashboardServer) handleAPIAlerts(w http.ResponseWriter, r *http.Request) {
+	state := AlertState(r.URL.Query().Get("state"))
+	groups := make([]AlertGroup, 0)
+	for _, g := range ds.collector.AlertGroups() {
+		if state == "" || g.State == state {
+			groups = append(groups, g)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
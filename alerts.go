@@ -3,7 +3,6 @@ package xrayhq
 import (
 	"fmt"
 	"strings"
-	"time"
 )
 
 type AlertEngine struct {
@@ -18,12 +17,28 @@ func NewAlertEngine(collector *Collector, config *Config) *AlertEngine {
 func (e *AlertEngine) Evaluate(trace *RequestTrace) {
 	e.checkNPlusOne(trace)
 	e.checkSlowQueries(trace)
-	e.checkSlowRoute(trace)
-	e.checkHighErrorRate(trace)
+	if w := e.collector.recentRoute(trace.Method, trace.RoutePattern); w != nil && w.TotalRequests >= 10 {
+		e.checkSlowRoute(trace, w)
+		e.checkHighErrorRate(trace, w)
+	}
 	e.checkMemorySpike(trace)
 	e.checkPanic(trace)
 }
 
+// fire attaches alert to trace and records it in its alert group.
+func (e *AlertEngine) fire(trace *RequestTrace, alert Alert) {
+	alert.Method = trace.Method
+	alert.Fingerprint = alertFingerprint(alert.Type, alert.Method, alert.RoutePattern, alert.Pattern)
+	trace.Alerts = append(trace.Alerts, alert)
+	e.collector.AddAlert(alert)
+}
+
+// clear resolves the route's alert group of the given type, once the
+// condition behind it no longer holds.
+func (e *AlertEngine) clear(trace *RequestTrace, alertType string) {
+	e.collector.alertGroups.clear(alertFingerprint(alertType, trace.Method, trace.RoutePattern, ""))
+}
+
 func (e *AlertEngine) checkNPlusOne(trace *RequestTrace) {
 	if len(trace.DBQueries) == 0 {
 		return
@@ -42,11 +57,11 @@ func (e *AlertEngine) checkNPlusOne(trace *RequestTrace) {
 				Severity:     SeverityWarning,
 				RoutePattern: trace.RoutePattern,
 				RequestID:    trace.ID,
-				Timestamp:    time.Now(),
+				Timestamp:    e.collector.now(),
 				Details:      map[string]interface{}{"pattern": pattern, "count": count},
+				Pattern:      pattern,
 			}
-			trace.Alerts = append(trace.Alerts, alert)
-			e.collector.AddAlert(alert)
+			e.fire(trace, alert)
 		}
 	}
 }
@@ -61,75 +76,89 @@ func (e *AlertEngine) checkSlowQueries(trace *RequestTrace) {
 				Severity:     SeverityWarning,
 				RoutePattern: trace.RoutePattern,
 				RequestID:    trace.ID,
-				Timestamp:    time.Now(),
+				Timestamp:    e.collector.now(),
 				Details:      map[string]interface{}{"query": q.Query, "duration_ms": q.Duration.Milliseconds()},
+				Pattern:      queryPattern(q.Query),
 			}
-			trace.Alerts = append(trace.Alerts, alert)
-			e.collector.AddAlert(alert)
+			e.fire(trace, alert)
 		}
 	}
 }
 
-func (e *AlertEngine) checkSlowRoute(trace *RequestTrace) {
-	rm := e.collector.GetRoute(trace.Method, trace.RoutePattern)
-	if rm == nil || rm.TotalRequests < 10 {
+// checkSlowRoute and checkHighErrorRate judge a route on its recent window,
+// once it has at least 10 requests, so their alerts resolve once the route
+// recovers.
+func (e *AlertEngine) checkSlowRoute(trace *RequestTrace, w *RouteWindow) {
+	p95 := w.P95()
+	if p95 <= e.config.SlowRouteP95Threshold {
+		e.clear(trace, "slow_route")
 		return
 	}
-	if rm.P95() > e.config.SlowRouteP95Threshold {
-		alert := Alert{
-			ID:           generateID(),
-			Type:         "slow_route",
-			Message:      fmt.Sprintf("Slow route: %s %s P95=%v", trace.Method, trace.RoutePattern, rm.P95()),
-			Severity:     SeverityWarning,
-			RoutePattern: trace.RoutePattern,
-			RequestID:    trace.ID,
-			Timestamp:    time.Now(),
-			Details:      map[string]interface{}{"p95_ms": rm.P95().Milliseconds()},
-		}
-		trace.Alerts = append(trace.Alerts, alert)
-		e.collector.AddAlert(alert)
-	}
+	e.fire(trace, Alert{
+		ID:           generateID(),
+		Type:         "slow_route",
+		Message:      fmt.Sprintf("Slow route: %s %s P95=%v", trace.Method, trace.RoutePattern, p95),
+		Severity:     SeverityWarning,
+		RoutePattern: trace.RoutePattern,
+		RequestID:    trace.ID,
+		Timestamp:    e.collector.now(),
+		Details:      map[string]interface{}{"p95_ms": p95.Milliseconds()},
+	})
 }
 
-func (e *AlertEngine) checkHighErrorRate(trace *RequestTrace) {
-	rm := e.collector.GetRoute(trace.Method, trace.RoutePattern)
-	if rm == nil || rm.TotalRequests < 10 {
+func (e *AlertEngine) checkHighErrorRate(trace *RequestTrace, w *RouteWindow) {
+	rate := w.ErrorRate()
+	if rate <= e.config.HighErrorRatePercent {
+		e.clear(trace, "high_error_rate")
 		return
 	}
-	if rm.ErrorRate() > e.config.HighErrorRatePercent {
-		alert := Alert{
-			ID:           generateID(),
-			Type:         "high_error_rate",
-			Message:      fmt.Sprintf("High error rate: %s %s at %.1f%%", trace.Method, trace.RoutePattern, rm.ErrorRate()),
-			Severity:     SeverityCritical,
-			RoutePattern: trace.RoutePattern,
-			RequestID:    trace.ID,
-			Timestamp:    time.Now(),
-			Details:      map[string]interface{}{"error_rate": rm.ErrorRate()},
-		}
-		trace.Alerts = append(trace.Alerts, alert)
-		e.collector.AddAlert(alert)
-	}
+	e.fire(trace, Alert{
+		ID:           generateID(),
+		Type:         "high_error_rate",
+		Message:      fmt.Sprintf("High error rate: %s %s at %.1f%%", trace.Method, trace.RoutePattern, rate),
+		Severity:     SeverityCritical,
+		RoutePattern: trace.RoutePattern,
+		RequestID:    trace.ID,
+		Timestamp:    e.collector.now(),
+		Details:      map[string]interface{}{"error_rate": rate},
+	})
 }
 
 func (e *AlertEngine) checkMemorySpike(trace *RequestTrace) {
-	if trace.MemAllocAfter > trace.MemAllocBefore {
-		delta := trace.MemAllocAfter - trace.MemAllocBefore
-		if delta > e.config.MemorySpikeBytes {
-			alert := Alert{
-				ID:           generateID(),
-				Type:         "memory_spike",
-				Message:      fmt.Sprintf("Memory spike: %s %s allocated %s", trace.Method, trace.Path, formatBytes(delta)),
-				Severity:     SeverityWarning,
-				RoutePattern: trace.RoutePattern,
-				RequestID:    trace.ID,
-				Timestamp:    time.Now(),
-				Details:      map[string]interface{}{"bytes_allocated": delta},
-			}
-			trace.Alerts = append(trace.Alerts, alert)
-			e.collector.AddAlert(alert)
-		}
+	if trace.MemAllocAfter <= trace.MemAllocBefore {
+		return
+	}
+	// The heap counter is process-wide, so the raw delta includes whatever
+	// concurrent requests allocated. Alert on this request's even share.
+	delta := trace.MemAllocAfter - trace.MemAllocBefore
+	concurrent := trace.ConcurrentRequests
+	if concurrent < 1 {
+		concurrent = 1
+	}
+	share := delta / uint64(concurrent)
+	if share <= e.config.MemorySpikeBytes {
+		return
 	}
+	msg := fmt.Sprintf("Memory spike: %s %s allocated %s", trace.Method, trace.Path, formatBytes(share))
+	if concurrent > 1 {
+		msg = fmt.Sprintf("Memory spike: %s %s allocated ~%s (%s across %d concurrent requests)",
+			trace.Method, trace.Path, formatBytes(share), formatBytes(delta), concurrent)
+	}
+	alert := Alert{
+		ID:           generateID(),
+		Type:         "memory_spike",
+		Message:      msg,
+		Severity:     SeverityWarning,
+		RoutePattern: trace.RoutePattern,
+		RequestID:    trace.ID,
+		Timestamp:    e.collector.now(),
+		Details: map[string]interface{}{
+			"bytes_allocated":     delta,
+			"estimated_bytes":     share,
+			"concurrent_requests": concurrent,
+		},
+	}
+	e.fire(trace, alert)
 }
 
 func (e *AlertEngine) checkPanic(trace *RequestTrace) {
@@ -141,11 +170,10 @@ func (e *AlertEngine) checkPanic(trace *RequestTrace) {
 			Severity:     SeverityCritical,
 			RoutePattern: trace.RoutePattern,
 			RequestID:    trace.ID,
-			Timestamp:    time.Now(),
+			Timestamp:    e.collector.now(),
 			Details:      map[string]interface{}{"panic_value": fmt.Sprintf("%v", trace.PanicValue)},
 		}
-		trace.Alerts = append(trace.Alerts, alert)
-		e.collector.AddAlert(alert)
+		e.fire(trace, alert)
 	}
 }
 
@@ -1,6 +1,7 @@
 package xrayhq
 
 import (
+	"fmt"
 	"testing"
 	"time"
 )
@@ -142,3 +143,151 @@ func TestNoFalseAlerts(t *testing.T) {
 		t.Errorf("expected 0 alerts for healthy request, got %d", len(trace.Alerts))
 	}
 }
+
+func TestAlertMemorySpikeSharedAcrossConcurrentRequests(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MemorySpikeBytes = 1000
+	c := NewCollector(cfg)
+	engine := NewAlertEngine(c, cfg)
+
+	trace := &RequestTrace{
+		ID:                 "test-6",
+		Method:             "GET",
+		Path:               "/busy",
+		RoutePattern:       "/busy",
+		MemAllocBefore:     1000,
+		MemAllocAfter:      5000,
+		ConcurrentRequests: 8,
+	}
+	engine.Evaluate(trace)
+	if len(trace.Alerts) != 0 {
+		t.Errorf("expected allocations shared across concurrent requests not to alert, got %v", trace.Alerts)
+	}
+
+	trace.MemAllocAfter = 20000
+	engine.Evaluate(trace)
+	if len(trace.Alerts) != 1 || trace.Alerts[0].Details["concurrent_requests"] != 8 {
+		t.Errorf("expected one memory_spike alert with concurrency details, got %v", trace.Alerts)
+	}
+}
+
+func slowQueryTrace(route, query string) *RequestTrace {
+	return &RequestTrace{
+		Method:       "GET",
+		RoutePattern: route,
+		DBQueries:    []DBQuery{{Query: query, Duration: time.Second}},
+	}
+}
+
+func TestAlertGrouping(t *testing.T) {
+	cfg := DefaultConfig()
+	c := NewCollector(cfg)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	first := now
+	for i := 0; i < 50; i++ {
+		c.alertEngine.Evaluate(slowQueryTrace("/orders", fmt.Sprintf("SELECT * FROM orders WHERE id = %d", i)))
+		now = now.Add(time.Second)
+	}
+	c.alertEngine.Evaluate(slowQueryTrace("/orders", "SELECT * FROM users WHERE id = 1"))
+	c.alertEngine.Evaluate(slowQueryTrace("/users", "SELECT * FROM orders WHERE id = 1"))
+
+	groups := c.AlertGroups()
+	if len(groups) != 3 {
+		t.Fatalf("expected 3 groups by route and query pattern, got %d", len(groups))
+	}
+	var orders AlertGroup
+	for _, g := range groups {
+		if g.RoutePattern == "/orders" && g.Pattern == "SELECT orders" {
+			orders = g
+		}
+	}
+	if orders.Count != 50 || !orders.FirstSeen.Equal(first) || !orders.LastSeen.Equal(first.Add(49*time.Second)) || orders.State != AlertFiring {
+		t.Errorf("unexpected group %+v", orders)
+	}
+	if len(c.GetAlerts()) != 3 {
+		t.Errorf("expected one latest alert per group, got %d", len(c.GetAlerts()))
+	}
+}
+
+func TestAlertLifecycle(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AlertResolveAfter = 10 * time.Minute
+	cfg.AlertRetention = time.Hour
+	c := NewCollector(cfg)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	fire := func() AlertGroup {
+		c.alertEngine.Evaluate(slowQueryTrace("/orders", "SELECT * FROM orders"))
+		return c.AlertGroups()[0]
+	}
+
+	g := fire()
+	if g, err := c.AcknowledgeAlert(g.Fingerprint); err != nil || g.State != AlertAcknowledged {
+		t.Fatalf("expected acknowledged, got %v, %v", g.State, err)
+	}
+	if _, err := c.AcknowledgeAlert(g.Fingerprint); err == nil {
+		t.Error("expected an error acknowledging twice")
+	}
+	if g := fire(); g.State != AlertAcknowledged || g.Count != 2 {
+		t.Errorf("expected new occurrences counted while acknowledged, got %s x%d", g.State, g.Count)
+	}
+	if g, _ := c.ResolveAlert(g.Fingerprint); g.State != AlertResolved {
+		t.Errorf("expected resolved, got %s", g.State)
+	}
+	if g := fire(); g.State != AlertFiring || !g.AcknowledgedAt.IsZero() {
+		t.Errorf("expected a resolved group to fire again, got %s", g.State)
+	}
+	if _, err := c.ResolveAlert("missing"); err != ErrAlertNotFound {
+		t.Errorf("expected ErrAlertNotFound, got %v", err)
+	}
+
+	now = now.Add(11 * time.Minute)
+	if g, _ := c.AlertGroup(g.Fingerprint); g.State != AlertResolved || c.ActiveAlertCount() != 0 {
+		t.Errorf("expected a quiet group resolved automatically, got %s", g.State)
+	}
+	now = now.Add(time.Hour)
+	if _, ok := c.AlertGroup(g.Fingerprint); ok {
+		t.Error("expected the resolved group dropped after the retention period")
+	}
+}
+
+func TestAlertGroupCap(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxAlertGroups = 5
+	c := NewCollector(cfg)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 20; i++ {
+		c.alertEngine.Evaluate(slowQueryTrace(fmt.Sprint("/r", i), "SELECT 1"))
+		now = now.Add(time.Second)
+	}
+	groups := c.AlertGroups()
+	if len(groups) != 5 || groups[0].RoutePattern != "/r19" || groups[4].RoutePattern != "/r15" {
+		t.Errorf("expected the 5 most recent groups kept, got %d", len(groups))
+	}
+}
+
+func TestAlertResolvesWhenRouteRecovers(t *testing.T) {
+	cfg := DefaultConfig()
+	c := NewCollector(cfg)
+	record := func(status int) {
+		c.Record(&RequestTrace{ID: generateID(), Method: "GET", RoutePattern: "/pay", ResponseStatus: status, StartTime: time.Now()})
+	}
+	for i := 0; i < 10; i++ {
+		record(500)
+	}
+	groups := c.AlertGroups()
+	if len(groups) != 1 || groups[0].Type != "high_error_rate" || groups[0].State != AlertFiring {
+		t.Fatalf("expected one firing error rate alert, got %+v", groups)
+	}
+	for i := 0; i < 200; i++ {
+		record(200)
+	}
+	// Every request fires until the error rate is back at 10%.
+	if groups := c.AlertGroups(); len(groups) != 1 || groups[0].State != AlertResolved || groups[0].Count != 90 {
+		t.Errorf("expected one alert resolved once the error rate recovered, got %+v", groups)
+	}
+}
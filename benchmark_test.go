@@ -1,9 +1,11 @@
 package xrayhq
 
 import (
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func BenchmarkMiddleware(b *testing.B) {
@@ -91,3 +93,125 @@ func BenchmarkCollectorRecordParallel(b *testing.B) {
 		}
 	})
 }
+
+func BenchmarkMiddlewareRuntimeSampling(b *testing.B) {
+	for _, every := range []int{0, 1, 100} {
+		b.Run(fmt.Sprintf("every=%d", every), func(b *testing.B) {
+			cfg := DefaultConfig()
+			cfg.CaptureBody = false
+			cfg.CaptureHeaders = false
+			cfg.RuntimeSampling = every
+			c := NewCollector(cfg)
+
+			wrapped := coreMiddleware(c, cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(200)
+			}))
+
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				req := httptest.NewRequest("GET", "/api/test", nil)
+				for pb.Next() {
+					wrapped.ServeHTTP(httptest.NewRecorder(), req)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkReadRuntimeStats(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		readRuntimeStats()
+	}
+}
+
+// The store lookups below should cost about the same at every buffer size:
+// they touch only the index entries they return, plus a logarithmic search.
+
+// benchmarkStore fills a store with size traces spread over 100 routes, one
+// millisecond apart from start.
+func benchmarkStore(size int, start time.Time) Store {
+	s := NewMemoryStore(size)
+	for i := 0; i < size; i++ {
+		s.Append(&RequestTrace{
+			ID:             fmt.Sprint("t", i),
+			Method:         "GET",
+			RoutePattern:   fmt.Sprint("/route/", i%100),
+			ResponseStatus: 200,
+			StartTime:      start.Add(time.Duration(i) * time.Millisecond),
+		})
+	}
+	s.Append(&RequestTrace{ID: "rare", Method: "GET", RoutePattern: "/rare", ResponseStatus: 503, StartTime: start})
+	return s
+}
+
+func BenchmarkStoreGetByID(b *testing.B) {
+	for _, size := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			s := benchmarkStore(size, time.Now())
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.Get("t500")
+			}
+		})
+	}
+}
+
+// BenchmarkStoreRouteLookup reads the 10 most recent traces of a route,
+// which has at least that many at every size.
+func BenchmarkStoreRouteLookup(b *testing.B) {
+	for _, size := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			s := benchmarkStore(size, time.Now())
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				n := 0
+				s.Iterate(TraceFilter{Method: "GET", RoutePattern: "/route/7"}, func(*RequestTrace) bool {
+					n++
+					return n < 10
+				})
+			}
+		})
+	}
+}
+
+func BenchmarkStoreStatusLookup(b *testing.B) {
+	for _, size := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			s := benchmarkStore(size, time.Now())
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.Iterate(TraceFilter{MinStatus: 500}, func(*RequestTrace) bool { return true })
+			}
+		})
+	}
+}
+
+func BenchmarkStoreRecentWindow(b *testing.B) {
+	for _, size := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			start := time.Now()
+			s := benchmarkStore(size, start)
+			since := start.Add(time.Duration(size-100) * time.Millisecond)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.Iterate(TraceFilter{Since: since}, func(*RequestTrace) bool { return true })
+			}
+		})
+	}
+}
+
+func BenchmarkRouteMetricsP95(b *testing.B) {
+	for _, size := range []int{10000, 1000000} {
+		b.Run(fmt.Sprintf("requests=%d", size), func(b *testing.B) {
+			rm := NewRouteMetrics("/bench", "GET", 0)
+			for i := 0; i < size; i++ {
+				rm.Record(&RequestTrace{Latency: time.Duration(i%5000) * time.Microsecond, ResponseStatus: 200})
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				rm.P95()
+			}
+		})
+	}
+}
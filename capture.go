@@ -0,0 +1,125 @@
+package xrayhq
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"strings"
+)
+
+// defaultSkipContentTypes are body types that are rarely readable on the
+// request detail page and often large.
+var defaultSkipContentTypes = []string{
+	"multipart/*",
+	"image/*",
+	"audio/*",
+	"video/*",
+	"font/*",
+	"application/octet-stream",
+	"application/zip",
+	"application/gzip",
+	"application/pdf",
+	"application/grpc",
+}
+
+// shouldCaptureBody reports whether a body with the given Content-Type
+// header should be captured. Bodies without a content type are captured.
+func shouldCaptureBody(cfg *Config, contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+	}
+	for _, pattern := range cfg.SkipContentTypes {
+		if matchContentType(pattern, mediaType) {
+			return false
+		}
+	}
+	if len(cfg.CaptureContentTypes) == 0 {
+		return true
+	}
+	for _, pattern := range cfg.CaptureContentTypes {
+		if matchContentType(pattern, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchContentType matches a media type against "type/subtype", "type/*"
+// or "*/*".
+func matchContentType(pattern, mediaType string) bool {
+	pattern = strings.ToLower(pattern)
+	if pattern == "*/*" || pattern == mediaType {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return strings.HasPrefix(mediaType, prefix+"/")
+	}
+	return false
+}
+
+// appendCapped appends as much of p to buf as fits in limit bytes and
+// reports whether anything was left out. A limit of 0 means no limit.
+func appendCapped(buf *bytes.Buffer, p []byte, limit int) (truncated bool) {
+	if limit <= 0 {
+		buf.Write(p)
+		return false
+	}
+	room := limit - buf.Len()
+	if room <= 0 {
+		return len(p) > 0
+	}
+	if len(p) > room {
+		buf.Write(p[:room])
+		return true
+	}
+	buf.Write(p)
+	return false
+}
+
+// captureReader copies what the handler reads from a request body, up to a
+// limit, without reading ahead of it. Bodies the handler never reads are not
+// captured.
+type captureReader struct {
+	io.ReadCloser
+	buf       bytes.Buffer
+	limit     int
+	n         int64
+	truncated bool
+}
+
+func newCaptureReader(body io.ReadCloser, limit int) *captureReader {
+	return &captureReader{ReadCloser: body, limit: limit}
+}
+
+func (c *captureReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	if appendCapped(&c.buf, p[:n], c.limit) {
+		c.truncated = true
+	}
+	return n, err
+}
+
+// captureCapped copies up to limit bytes of an already buffered body and
+// reports whether it was cut short. A limit of 0 means no limit.
+func captureCapped(body []byte, limit int) (captured []byte, truncated bool) {
+	if len(body) == 0 {
+		return nil, false
+	}
+	if limit > 0 && len(body) > limit {
+		body, truncated = body[:limit], true
+	}
+	return bytes.Clone(body), truncated
+}
+
+// capturedBytes returns the captured bytes, or nil if nothing was read.
+func capturedBytes(buf *bytes.Buffer) []byte {
+	if buf.Len() == 0 {
+		return nil
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,106 @@
+// Command xrayhq serves the xrayhq dashboard for traces exported from a
+// running application, so a snapshot attached to an incident can be opened
+// without running the app.
+//
+// Usage:
+//
+//	xrayhq [-addr 127.0.0.1:9090] FILE|DIR...
+//
+// Each FILE is a JSON export from /xrayhq/export?format=json or an NDJSON
+// file with one trace per line. A DIR is read as a DiskStore directory.
+// Route metrics and alerts are rebuilt from the traces.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/Bhavyyadav25/xrayhq"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:9090", "address to serve the dashboard on")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: xrayhq [-addr host:port] FILE|DIR...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var traces []*xrayhq.RequestTrace
+	for _, arg := range flag.Args() {
+		files, err := expand(arg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, name := range files {
+			t, err := readFile(name)
+			if err != nil {
+				log.Fatalf("%s: %v", name, err)
+			}
+			traces = append(traces, t...)
+		}
+	}
+
+	// Keep every trace: no buffer limits and no sampling.
+	x, err := xrayhq.New(
+		xrayhq.WithPort(*addr),
+		xrayhq.WithBufferSize(max(len(traces), 1)),
+		xrayhq.WithMaxBufferBytes(0),
+		xrayhq.WithRuntimeSampling(0),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	n, err := x.Collector().Import(traces)
+	if err != nil {
+		log.Fatalf("importing traces: %v", err)
+	}
+	log.Printf("[xrayhq] Loaded %d traces across %d routes", n, x.Collector().RouteCount())
+
+	if err := x.Start(); err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	x.Shutdown(context.Background())
+}
+
+// expand returns the files to read for a command line argument: the file
+// itself, or the segment files of a DiskStore directory.
+func expand(arg string) ([]string, error) {
+	info, err := os.Stat(arg)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{arg}, nil
+	}
+	files, err := filepath.Glob(filepath.Join(arg, "segment-*.ndjson"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%s: no segment files found", arg)
+	}
+	return files, nil
+}
+
+func readFile(name string) ([]*xrayhq.RequestTrace, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return xrayhq.ReadTraces(f)
+}
@@ -1,62 +1,130 @@
 package xrayhq
 
 import (
+	"container/list"
+	"context"
+	"errors"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Collector struct {
-	mu         sync.RWMutex
-	buffer     []*RequestTrace
-	bufferSize int
-	head       int
-	count      int
+	mu    sync.RWMutex
+	store Store
 
-	routes     map[string]*RouteMetrics
-	alerts     []Alert
-	startTime  time.Time
+	routes      map[string]*RouteMetrics
+	routeLRU    *list.List
+	alertGroups *alertGroups
+	startTime   time.Time
 
 	config      *Config
+	redactor    *redactor
 	alertEngine *AlertEngine
 	sseClients  map[chan *RequestTrace]struct{}
 	sseMu       sync.Mutex
+	sseClosed   bool
+
+	adaptive        *adaptiveSampler
+	inFlight        atomic.Int64
+	requestsStarted atomic.Uint64
+	lateOps         atomic.Int64
+	sampledOut      atomic.Int64
+	storeErrors     atomic.Int64
+	collapsedRoutes atomic.Int64
+	evictedRoutes   atomic.Int64
+	exporter        *otlpExporter
+	now             func() time.Time
+	importedUntil   time.Time
 }
 
+// NewCollector creates a collector for cfg, storing traces in cfg.Store or,
+// if that is nil, in memory within cfg.BufferSize and cfg.MaxBufferBytes. It
+// does not validate cfg; New does, and it panics if neither limit is set.
+// Route metrics start from the traces already in cfg.Store, if any.
 func NewCollector(cfg *Config) *Collector {
+	store := cfg.Store
+	if store == nil {
+		store = NewMemoryStoreWithOptions(MemoryStoreOptions{
+			MaxTraces:  cfg.BufferSize,
+			MaxBytes:   cfg.MaxBufferBytes,
+			ShedBodies: cfg.ShedBodies,
+		})
+	}
 	c := &Collector{
-		buffer:     make([]*RequestTrace, cfg.BufferSize),
-		bufferSize: cfg.BufferSize,
+		store:      store,
 		routes:     make(map[string]*RouteMetrics),
-		alerts:     make([]Alert, 0),
+		routeLRU:   list.New(),
 		startTime:  time.Now(),
 		config:     cfg,
+		redactor:   newRedactor(cfg.Redaction),
 		sseClients: make(map[chan *RequestTrace]struct{}),
+		now:        time.Now,
 	}
+	c.alertGroups = newAlertGroups(cfg, func() time.Time { return c.now() })
 	c.alertEngine = NewAlertEngine(c, cfg)
+	if cfg.MaxTracesPerSecond > 0 {
+		c.adaptive = newAdaptiveSampler(cfg.MaxTracesPerSecond, cfg.AdaptiveSamplingInterval)
+	}
+	if cfg.OTLP != nil && cfg.OTLP.Endpoint != "" {
+		c.exporter = newOTLPExporter(*cfg.OTLP)
+	}
+	if cfg.Store != nil {
+		c.rebuildRoutes()
+	}
 	return c
 }
 
 func (c *Collector) Record(trace *RequestTrace) {
-	c.mu.Lock()
-	c.buffer[c.head] = trace
-	c.head = (c.head + 1) % c.bufferSize
-	if c.count < c.bufferSize {
-		c.count++
+	// Redact first, so nothing downstream (metrics, alerts, the store,
+	// export, SSE) ever sees the raw values.
+	c.redactor.apply(trace)
+	if trace.SampleRate <= 0 {
+		trace.SampleRate = 1
 	}
 
-	key := trace.Method + " " + trace.RoutePattern
-	rm, ok := c.routes[key]
-	if !ok {
-		rm = NewRouteMetrics(trace.RoutePattern, trace.Method, c.config.LatencyCap)
-		c.routes[key] = rm
-	}
+	c.mu.Lock()
+	rm := c.routeFor(trace)
 	rm.Record(trace)
 	c.mu.Unlock()
+	key := trace.Method + " " + trace.RoutePattern
 
-	// Evaluate alert rules
+	// Evaluate alert rules before the trace enters the store, so readers
+	// never observe it changing.
 	c.alertEngine.Evaluate(trace)
 
+	if c.config.SamplingMode == SamplingTail {
+		reason, rate := tailSampleReason(c.config, trace)
+		if reason == "" {
+			c.sampledOut.Add(1)
+			return
+		}
+		trace.SampleReason = reason
+		trace.SampleRate *= rate
+	} else if trace.SampleReason == "" {
+		trace.SampleReason = SampleReasonSampled
+	}
+
+	// Adaptive sampling only thins out regular traffic; traces kept for a
+	// reason or by a capture rule always go through.
+	if c.adaptive != nil && trace.SampleReason == SampleReasonSampled {
+		keep, rate := c.adaptive.sample(key)
+		if !keep {
+			c.sampledOut.Add(1)
+			return
+		}
+		trace.SampleRate *= rate
+	}
+
+	if err := c.store.Append(trace); err != nil {
+		c.storeErrors.Add(1)
+	}
+
+	if c.exporter != nil {
+		c.exporter.enqueue(trace)
+	}
+
 	// Notify SSE clients
 	c.sseMu.Lock()
 	for ch := range c.sseClients {
@@ -68,60 +136,110 @@ func (c *Collector) Record(trace *RequestTrace) {
 	c.sseMu.Unlock()
 }
 
+// Shutdown closes all SSE subscribers, flushes traces queued for export and
+// closes the store. It returns ctx's error if the flush does not finish in
+// time.
+func (c *Collector) Shutdown(ctx context.Context) error {
+	c.closeSSE()
+	var err error
+	if c.exporter != nil {
+		err = c.exporter.shutdown(ctx)
+	}
+	return errors.Join(err, c.store.Close())
+}
+
+// AddAlert records an occurrence of an alert in its group, filling in the
+// fingerprint if it is not set.
 func (c *Collector) AddAlert(a Alert) {
-	c.mu.Lock()
-	c.alerts = append(c.alerts, a)
-	c.mu.Unlock()
+	if a.Fingerprint == "" {
+		a.Fingerprint = alertFingerprint(a.Type, a.Method, a.RoutePattern, a.Pattern)
+	}
+	c.alertGroups.add(a)
 }
 
+// GetAlerts returns the latest occurrence of each retained alert group,
+// oldest first. Use AlertGroups for counts and states.
 func (c *Collector) GetAlerts() []Alert {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	out := make([]Alert, len(c.alerts))
-	copy(out, c.alerts)
+	groups := c.alertGroups.list()
+	sort.Slice(groups, func(i, j int) bool { return groups[i].LastSeen.Before(groups[j].LastSeen) })
+	out := make([]Alert, len(groups))
+	for i, g := range groups {
+		out[i] = g.Last
+	}
 	return out
 }
 
 func (c *Collector) GetRecentRequests(limit int) []*RequestTrace {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	return c.collect(TraceFilter{}, limit)
+}
 
-	if limit <= 0 || limit > c.count {
-		limit = c.count
-	}
+func (c *Collector) GetAllRequests() []*RequestTrace {
+	return c.collect(TraceFilter{}, 0)
+}
 
-	result := make([]*RequestTrace, 0, limit)
-	for i := 0; i < limit; i++ {
-		idx := (c.head - 1 - i + c.bufferSize) % c.bufferSize
-		if c.buffer[idx] != nil {
-			result = append(result, c.buffer[idx])
-		}
+func (c *Collector) GetRequestByID(id string) *RequestTrace {
+	t, err := c.store.Get(id)
+	if err != nil {
+		c.storeErrors.Add(1)
 	}
-	return result
+	return t
 }
 
-func (c *Collector) GetAllRequests() []*RequestTrace {
-	return c.GetRecentRequests(c.count)
+// Iterate calls fn for every stored trace matching filter, newest first,
+// until fn returns false. Unlike GetAllRequests it does not copy the buffer.
+func (c *Collector) Iterate(filter TraceFilter, fn func(*RequestTrace) bool) {
+	if err := c.store.Iterate(filter, fn); err != nil {
+		c.storeErrors.Add(1)
+	}
 }
 
-func (c *Collector) GetRequestByID(id string) *RequestTrace {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	for i := 0; i < c.count; i++ {
-		idx := (c.head - 1 - i + c.bufferSize) % c.bufferSize
-		if c.buffer[idx] != nil && c.buffer[idx].ID == id {
-			return c.buffer[idx]
+// Search returns up to limit traces matching q, newest first, after
+// skipping the first offset matches. more reports whether further matches
+// follow.
+func (c *Collector) Search(q *Query, offset, limit int) (traces []*RequestTrace, more bool) {
+	traces = make([]*RequestTrace, 0)
+	skipped := 0
+	c.Iterate(q.Filter(), func(t *RequestTrace) bool {
+		if !q.Match(t) {
+			return true
 		}
-	}
-	return nil
+		if skipped < offset {
+			skipped++
+			return true
+		}
+		if len(traces) == limit {
+			more = true
+			return false
+		}
+		traces = append(traces, t)
+		return true
+	})
+	return traces, more
+}
+
+// collect returns up to limit traces matching filter, newest first. A
+// non-positive limit returns all of them.
+func (c *Collector) collect(filter TraceFilter, limit int) []*RequestTrace {
+	result := make([]*RequestTrace, 0)
+	c.Iterate(filter, func(t *RequestTrace) bool {
+		result = append(result, t)
+		return limit <= 0 || len(result) < limit
+	})
+	return result
+}
+
+// Store returns the store the collector keeps traces in.
+func (c *Collector) Store() Store {
+	return c.store
 }
 
 func (c *Collector) GetRoutes() []*RouteMetrics {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
+	now := c.now()
 	result := make([]*RouteMetrics, 0, len(c.routes))
 	for _, rm := range c.routes {
-		result = append(result, rm.Snapshot())
+		result = append(result, rm.snapshotAt(now))
 	}
 	sort.Slice(result, func(i, j int) bool {
 		return result[i].TotalRequests > result[j].TotalRequests
@@ -134,50 +252,130 @@ func (c *Collector) GetRoute(method, pattern string) *RouteMetrics {
 	defer c.mu.RUnlock()
 	key := method + " " + pattern
 	if rm, ok := c.routes[key]; ok {
-		return rm.Snapshot()
+		return rm.snapshotAt(c.now())
 	}
 	return nil
 }
 
-func (c *Collector) GetRequestsForRoute(method, pattern string, limit int) []*RequestTrace {
+// recentRoute returns a route's recent window without copying its metrics,
+// for checks that run on every request.
+func (c *Collector) recentRoute(method, pattern string) *RouteWindow {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
+	rm, ok := c.routes[method+" "+pattern]
+	if !ok {
+		return nil
+	}
+	return rm.recentAt(c.now())
+}
 
-	result := make([]*RequestTrace, 0)
-	for i := 0; i < c.count; i++ {
-		idx := (c.head - 1 - i + c.bufferSize) % c.bufferSize
-		t := c.buffer[idx]
-		if t != nil && t.Method == method && t.RoutePattern == pattern {
-			result = append(result, t)
-			if len(result) >= limit {
-				break
-			}
-		}
+// RouteWindow returns a route's metrics over [since, until), built from
+// per-minute rollups kept for RollupRetention. It returns nil for an
+// unknown route.
+func (c *Collector) RouteWindow(method, pattern string, since, until time.Time) *RouteWindow {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	rm, ok := c.routes[method+" "+pattern]
+	if !ok {
+		return nil
 	}
-	return result
+	return rm.Window(since, until)
+}
+
+// RouteSeries returns a route's rollup buckets over [since, until), one per
+// RollupResolution, including empty ones. It returns nil for an unknown
+// route.
+func (c *Collector) RouteSeries(method, pattern string, since, until time.Time) []RollupBucket {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	rm, ok := c.routes[method+" "+pattern]
+	if !ok || rm.rollups == nil {
+		return nil
+	}
+	return rm.rollups.series(since, until)
+}
+
+func (c *Collector) GetRequestsForRoute(method, pattern string, limit int) []*RequestTrace {
+	return c.collect(TraceFilter{Method: method, RoutePattern: pattern}, limit)
 }
 
+// SubscribeSSE returns a channel that receives every recorded trace. After
+// the collector is shut down it returns an already closed channel.
 func (c *Collector) SubscribeSSE() chan *RequestTrace {
 	ch := make(chan *RequestTrace, 64)
 	c.sseMu.Lock()
+	defer c.sseMu.Unlock()
+	if c.sseClosed {
+		close(ch)
+		return ch
+	}
 	c.sseClients[ch] = struct{}{}
-	c.sseMu.Unlock()
 	return ch
 }
 
+// UnsubscribeSSE removes and closes a channel returned by SubscribeSSE. It is
+// safe to call after the collector has already closed the channel.
 func (c *Collector) UnsubscribeSSE(ch chan *RequestTrace) {
 	c.sseMu.Lock()
-	delete(c.sseClients, ch)
-	c.sseMu.Unlock()
-	close(ch)
+	defer c.sseMu.Unlock()
+	if _, ok := c.sseClients[ch]; ok {
+		delete(c.sseClients, ch)
+		close(ch)
+	}
+}
+
+// closeSSE closes every subscriber so that streaming dashboard handlers
+// return, which lets the dashboard server shut down.
+func (c *Collector) closeSSE() {
+	c.sseMu.Lock()
+	defer c.sseMu.Unlock()
+	c.sseClosed = true
+	for ch := range c.sseClients {
+		delete(c.sseClients, ch)
+		close(ch)
+	}
 }
 
 func (c *Collector) Uptime() time.Duration {
 	return time.Since(c.startTime)
 }
 
+// LateOps returns how many operations finished after their request's trace
+// had already been recorded and were therefore dropped.
+func (c *Collector) LateOps() int64 {
+	return c.lateOps.Load()
+}
+
+// SampledOut returns how many finished requests tail or adaptive sampling
+// dropped after counting them in route metrics.
+func (c *Collector) SampledOut() int64 {
+	return c.sampledOut.Load()
+}
+
 func (c *Collector) RequestCount() int {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return c.count
+	return c.store.Len()
+}
+
+// ExportDropped returns how many traces were not exported because the OTLP
+// queue was full or the exporter had shut down.
+func (c *Collector) ExportDropped() int64 {
+	if c.exporter == nil {
+		return 0
+	}
+	return c.exporter.dropped.Load()
+}
+
+// ExportFailed returns how many traces were in OTLP batches that still
+// failed after all retries.
+func (c *Collector) ExportFailed() int64 {
+	if c.exporter == nil {
+		return 0
+	}
+	return c.exporter.failed.Load()
+}
+
+// StoreErrors returns how many store operations have failed, such as disk
+// writes of a DiskStore.
+func (c *Collector) StoreErrors() int64 {
+	return c.storeErrors.Load()
 }
@@ -1,6 +1,7 @@
 package xrayhq
 
 import (
+	"fmt"
 	"sync"
 	"testing"
 	"time"
@@ -181,3 +182,108 @@ func TestRouteMetricsPercentiles(t *testing.T) {
 		t.Errorf("P99 expected ~99ms, got %v", p99)
 	}
 }
+
+func TestCollectorTailSampling(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.SamplingMode = SamplingTail
+	cfg.SamplingRate = 0
+	cfg.TailSlowThreshold = 100 * time.Millisecond
+	c := NewCollector(cfg)
+
+	record := func(id string, status int, latency time.Duration, panicked bool) {
+		c.Record(&RequestTrace{
+			ID:             id,
+			Method:         "GET",
+			Path:           "/api/orders",
+			RoutePattern:   "/api/orders",
+			ResponseStatus: status,
+			Latency:        latency,
+			Panicked:       panicked,
+			StartTime:      time.Now(),
+		})
+	}
+	for i := 0; i < 20; i++ {
+		record(fmt.Sprintf("ok-%d", i), 200, time.Millisecond, false)
+	}
+	record("err", 503, time.Millisecond, false)
+	record("panic", 500, time.Millisecond, true)
+	record("slow", 200, 200*time.Millisecond, false)
+
+	rm := c.GetRoute("GET", "/api/orders")
+	if rm.TotalRequests != 23 || rm.ErrorCount != 2 {
+		t.Errorf("expected metrics over all 23 requests with 2 errors, got %d and %d", rm.TotalRequests, rm.ErrorCount)
+	}
+	if c.RequestCount() != 3 {
+		t.Fatalf("expected only error, panic and slow traces kept, got %d", c.RequestCount())
+	}
+	if c.SampledOut() != 20 {
+		t.Errorf("expected 20 sampled out, got %d", c.SampledOut())
+	}
+	for id, reason := range map[string]string{"err": SampleReasonError, "panic": SampleReasonPanic, "slow": SampleReasonSlow} {
+		if tr := c.GetRequestByID(id); tr == nil || tr.SampleReason != reason {
+			t.Errorf("expected %s kept with reason %s, got %+v", id, reason, tr)
+		}
+	}
+}
+
+func TestCollectorMaxRoutes(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxRoutes = 3
+	c := NewCollector(cfg)
+
+	for i := 0; i < 10; i++ {
+		c.Record(&RequestTrace{ID: fmt.Sprint("r", i), Method: "GET", RoutePattern: fmt.Sprintf("/wp-admin/%d", i), ResponseStatus: 404, StartTime: time.Now()})
+	}
+	c.Record(&RequestTrace{ID: "scan", Method: "SCAN", RoutePattern: "/x", ResponseStatus: 405, StartTime: time.Now()})
+
+	if n := c.RouteCount(); n != 5 {
+		t.Errorf("expected 3 routes plus 2 overflow routes, got %d", n)
+	}
+	if n := c.CollapsedRoutes(); n != 8 {
+		t.Errorf("expected 8 collapsed requests, got %d", n)
+	}
+	if rm := c.GetRoute("GET", OverflowRoute); rm == nil || rm.TotalRequests != 7 {
+		t.Fatalf("expected 7 requests in the overflow route, got %+v", rm)
+	}
+	if got := c.GetRequestsForRoute("GET", OverflowRoute, 100); len(got) != 7 {
+		t.Errorf("expected overflowed traces stored under the overflow route, got %d", len(got))
+	}
+	if tr := c.GetRequestByID("scan"); tr.Method != "OTHER" || tr.RoutePattern != OverflowRoute {
+		t.Errorf("expected a non-standard method folded into OTHER, got %s %s", tr.Method, tr.RoutePattern)
+	}
+	if c.GetRoute("GET", "/wp-admin/1") == nil {
+		t.Error("expected routes seen before the limit to keep their metrics")
+	}
+}
+
+func TestCollectorRouteIdleEviction(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxRoutes = 2
+	cfg.RouteIdleTTL = time.Minute
+	c := NewCollector(cfg)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	record := func(route string) {
+		c.Record(&RequestTrace{Method: "GET", RoutePattern: route, ResponseStatus: 200, StartTime: now})
+	}
+
+	record("/a")
+	record("/b")
+	now = now.Add(30 * time.Second)
+	record("/c")
+	if c.CollapsedRoutes() != 1 || c.EvictedRoutes() != 0 {
+		t.Errorf("expected no eviction before the TTL, got %d collapsed and %d evicted", c.CollapsedRoutes(), c.EvictedRoutes())
+	}
+
+	// /a is now the least recently used route and has been idle past the TTL;
+	// /b and the overflow route were used more recently.
+	now = now.Add(45 * time.Second)
+	record("/b")
+	record("/d")
+	if c.EvictedRoutes() != 1 || c.GetRoute("GET", "/a") != nil || c.GetRoute("GET", "/d") == nil {
+		t.Errorf("expected /a evicted to make room for /d, got %d evicted", c.EvictedRoutes())
+	}
+	if c.GetRoute("GET", "/b") == nil {
+		t.Error("expected the recently used route to be kept")
+	}
+}
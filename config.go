@@ -1,6 +1,10 @@
 package xrayhq
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
 
 type Mode string
 
@@ -10,54 +14,226 @@ const (
 )
 
 type Config struct {
-	Port          string
-	BufferSize    int
-	Mode          Mode
-	SamplingRate  float64
-	CaptureBody   bool
+	Port           string
+	BufferSize     int
+	Mode           Mode
+	SamplingRate   float64
+	SamplingMode   SamplingMode
+	CaptureBody    bool
 	CaptureHeaders bool
-	BasicAuthUser string
-	BasicAuthPass string
+	BasicAuthUser  string
+	BasicAuthPass  string
 
 	SlowQueryThreshold    time.Duration
 	SlowRouteP95Threshold time.Duration
 	HighErrorRatePercent  float64
 	NPlusOneThreshold     int
 	MemorySpikeBytes      uint64
-	LatencyCap            int
+
+	// Deprecated: LatencyCap is ignored. Route percentiles come from a
+	// LatencySketch, which stays current with bounded memory.
+	LatencyCap int
+
+	// SamplingRules override sampling and capture for matching requests,
+	// such as dropping health checks. The first matching rule wins.
+	SamplingRules []SamplingRule
+
+	// MaxTracesPerSecond caps how many regular traces are stored per route
+	// (method and pattern) and second. Each route still keeps at least one
+	// trace per AdaptiveSamplingInterval. Zero disables the cap.
+	MaxTracesPerSecond       float64
+	AdaptiveSamplingInterval time.Duration
+
+	// TailSlowThreshold is the latency above which tail sampling always keeps
+	// a request.
+	TailSlowThreshold time.Duration
+
+	// MaxBodyCaptureBytes caps how much of each request and response body is
+	// kept when CaptureBody is on. Zero means no limit.
+	MaxBodyCaptureBytes int
+	// CaptureContentTypes, when non-empty, limits body capture to these media
+	// types. Entries are "type/subtype" or "type/*".
+	CaptureContentTypes []string
+	// SkipContentTypes are media types whose bodies are never captured.
+	// It is checked before CaptureContentTypes.
+	SkipContentTypes []string
+
+	// Redaction masks credentials and other sensitive values before traces
+	// are stored. Use an empty RedactionConfig to keep everything verbatim.
+	Redaction RedactionConfig
+
+	// Store keeps recorded traces. When nil, the most recent traces are kept
+	// in memory, up to BufferSize of them and MaxBufferBytes of estimated
+	// size; either limit may be zero to disable it. With ShedBodies the
+	// oldest traces lose their bodies before whole traces are evicted.
+	Store          Store
+	MaxBufferBytes int64
+	ShedBodies     bool
+
+	// RuntimeSampling sets how often runtime stats (heap allocations and
+	// goroutines) are read around a request: 1 for every traced request, N
+	// for every Nth, and 0 to never read them.
+	RuntimeSampling int
+
+	// MaxRoutes caps how many distinct routes get their own metrics. Past
+	// it, requests for new routes are counted under OverflowRoute; with
+	// RouteIdleTTL set, a route idle for that long is evicted first to make
+	// room. Zero means no limit. Each route's rollups can take up to about
+	// 470KB (see RollupRetention), so lower it to bound memory on services
+	// with many busy routes.
+	MaxRoutes    int
+	RouteIdleTTL time.Duration
+
+	// Alerts are grouped by fingerprint. A group resolves once it has seen
+	// no alerts for AlertResolveAfter, or sooner when a route's error rate
+	// or P95 recovers. Resolved groups are dropped after AlertRetention, and
+	// at most MaxAlertGroups groups are kept. Zero disables each limit.
+	AlertResolveAfter time.Duration
+	AlertRetention    time.Duration
+	MaxAlertGroups    int
+
+	// PathNormalizer turns the raw path of requests no router matched into a
+	// route pattern. A nil normalizer groups such requests by raw path.
+	PathNormalizer PathNormalizer
+
+	// DashboardPrefix is the path the dashboard handler is mounted under,
+	// such as "/debug/xrayhq". Dashboard links are generated relative to it.
+	DashboardPrefix string
+
+	// OTLP, when set, exports every recorded trace to an OpenTelemetry
+	// collector in addition to keeping it for the dashboard.
+	OTLP *OTLPConfig
 }
 
 func DefaultConfig() *Config {
 	return &Config{
-		Port:                  ":9090",
-		BufferSize:            1000,
-		Mode:                  ModeDev,
-		SamplingRate:          1.0,
-		CaptureBody:          true,
-		CaptureHeaders:       true,
-		SlowQueryThreshold:    500 * time.Millisecond,
-		SlowRouteP95Threshold: 2 * time.Second,
-		HighErrorRatePercent:  10.0,
-		NPlusOneThreshold:     5,
-		MemorySpikeBytes:      10 * 1024 * 1024, // 10MB
-		LatencyCap:            10000,
+		Port:                     ":9090",
+		BufferSize:               1000,
+		MaxBufferBytes:           64 * 1024 * 1024, // 64MB
+		Mode:                     ModeDev,
+		SamplingRate:             1.0,
+		SamplingMode:             SamplingHead,
+		TailSlowThreshold:        time.Second,
+		AdaptiveSamplingInterval: time.Second,
+		RuntimeSampling:          1,
+		MaxRoutes:                1000,
+		AlertResolveAfter:        10 * time.Minute,
+		AlertRetention:           24 * time.Hour,
+		MaxAlertGroups:           500,
+		CaptureBody:              true,
+		CaptureHeaders:           true,
+		SlowQueryThreshold:       500 * time.Millisecond,
+		SlowRouteP95Threshold:    2 * time.Second,
+		HighErrorRatePercent:     10.0,
+		NPlusOneThreshold:        5,
+		MemorySpikeBytes:         10 * 1024 * 1024, // 10MB
+		MaxBodyCaptureBytes:      64 * 1024,        // 64KB
+		SkipContentTypes:         append([]string(nil), defaultSkipContentTypes...),
+		Redaction:                DefaultRedactionConfig(),
+		PathNormalizer:           DefaultPathNormalizer,
 	}
 }
 
+// Validate reports the first setting that would make the collector misbehave.
+func (c *Config) Validate() error {
+	if c.Store == nil && c.BufferSize <= 0 && c.MaxBufferBytes <= 0 {
+		return fmt.Errorf("xrayhq: BufferSize or MaxBufferBytes must be positive, got %d and %d", c.BufferSize, c.MaxBufferBytes)
+	}
+	if c.BufferSize < 0 || c.MaxBufferBytes < 0 {
+		return fmt.Errorf("xrayhq: BufferSize and MaxBufferBytes must not be negative")
+	}
+	if c.SamplingRate < 0 || c.SamplingRate > 1 {
+		return fmt.Errorf("xrayhq: SamplingRate must be between 0 and 1, got %v", c.SamplingRate)
+	}
+	if c.SamplingMode != "" && c.SamplingMode != SamplingHead && c.SamplingMode != SamplingTail {
+		return fmt.Errorf("xrayhq: unknown SamplingMode %q", c.SamplingMode)
+	}
+	if c.RuntimeSampling < 0 {
+		return fmt.Errorf("xrayhq: RuntimeSampling must not be negative, got %d", c.RuntimeSampling)
+	}
+	if c.MaxRoutes < 0 || c.RouteIdleTTL < 0 {
+		return errors.New("xrayhq: MaxRoutes and RouteIdleTTL must not be negative")
+	}
+	if c.AlertResolveAfter < 0 || c.AlertRetention < 0 || c.MaxAlertGroups < 0 {
+		return errors.New("xrayhq: alert limits must not be negative")
+	}
+	if c.MaxTracesPerSecond < 0 {
+		return fmt.Errorf("xrayhq: MaxTracesPerSecond must not be negative, got %v", c.MaxTracesPerSecond)
+	}
+	for i, rule := range c.SamplingRules {
+		switch rule.Action {
+		case RuleDrop, RuleCapture:
+		case RuleSample:
+			if rule.Rate < 0 || rule.Rate > 1 {
+				return fmt.Errorf("xrayhq: sampling rule %d: Rate must be between 0 and 1, got %v", i, rule.Rate)
+			}
+		default:
+			return fmt.Errorf("xrayhq: sampling rule %d: unknown Action %q", i, rule.Action)
+		}
+	}
+	if c.MaxBodyCaptureBytes < 0 {
+		return fmt.Errorf("xrayhq: MaxBodyCaptureBytes must not be negative, got %d", c.MaxBodyCaptureBytes)
+	}
+	if (c.BasicAuthUser == "") != (c.BasicAuthPass == "") {
+		return errors.New("xrayhq: basic auth needs both a user and a password")
+	}
+	return nil
+}
+
 type Option func(*Config)
 
-func WithPort(port string) Option { return func(c *Config) { c.Port = port } }
-func WithBufferSize(size int) Option { return func(c *Config) { c.BufferSize = size } }
-func WithMode(mode Mode) Option { return func(c *Config) { c.Mode = mode } }
-func WithSamplingRate(rate float64) Option { return func(c *Config) { c.SamplingRate = rate } }
-func WithCaptureBody(capture bool) Option { return func(c *Config) { c.CaptureBody = capture } }
+func WithPort(port string) Option            { return func(c *Config) { c.Port = port } }
+func WithBufferSize(size int) Option         { return func(c *Config) { c.BufferSize = size } }
+func WithMode(mode Mode) Option              { return func(c *Config) { c.Mode = mode } }
+func WithSamplingRate(rate float64) Option   { return func(c *Config) { c.SamplingRate = rate } }
+func WithCaptureBody(capture bool) Option    { return func(c *Config) { c.CaptureBody = capture } }
 func WithCaptureHeaders(capture bool) Option { return func(c *Config) { c.CaptureHeaders = capture } }
 func WithBasicAuth(user, pass string) Option {
 	return func(c *Config) { c.BasicAuthUser = user; c.BasicAuthPass = pass }
 }
-func WithSlowQueryThreshold(d time.Duration) Option { return func(c *Config) { c.SlowQueryThreshold = d } }
-func WithSlowRouteThreshold(d time.Duration) Option { return func(c *Config) { c.SlowRouteP95Threshold = d } }
+func WithSlowQueryThreshold(d time.Duration) Option {
+	return func(c *Config) { c.SlowQueryThreshold = d }
+}
+func WithSlowRouteThreshold(d time.Duration) Option {
+	return func(c *Config) { c.SlowRouteP95Threshold = d }
+}
 func WithHighErrorRate(pct float64) Option { return func(c *Config) { c.HighErrorRatePercent = pct } }
-func WithNPlusOneThreshold(n int) Option { return func(c *Config) { c.NPlusOneThreshold = n } }
-func WithMemorySpikeThreshold(bytes uint64) Option { return func(c *Config) { c.MemorySpikeBytes = bytes } }
-func WithLatencyCap(n int) Option                  { return func(c *Config) { c.LatencyCap = n } }
+func WithNPlusOneThreshold(n int) Option   { return func(c *Config) { c.NPlusOneThreshold = n } }
+func WithMemorySpikeThreshold(bytes uint64) Option {
+	return func(c *Config) { c.MemorySpikeBytes = bytes }
+}
+
+// Deprecated: WithLatencyCap has no effect; see Config.LatencyCap.
+func WithLatencyCap(n int) Option                 { return func(c *Config) { c.LatencyCap = n } }
+func WithPathNormalizer(fn PathNormalizer) Option { return func(c *Config) { c.PathNormalizer = fn } }
+func WithOTLPExporter(otlp OTLPConfig) Option     { return func(c *Config) { c.OTLP = &otlp } }
+func WithDashboardPrefix(prefix string) Option    { return func(c *Config) { c.DashboardPrefix = prefix } }
+func WithMaxBodyCaptureBytes(n int) Option        { return func(c *Config) { c.MaxBodyCaptureBytes = n } }
+func WithSamplingMode(mode SamplingMode) Option   { return func(c *Config) { c.SamplingMode = mode } }
+func WithTailSlowThreshold(d time.Duration) Option {
+	return func(c *Config) { c.TailSlowThreshold = d }
+}
+func WithMaxBufferBytes(n int64) Option       { return func(c *Config) { c.MaxBufferBytes = n } }
+func WithShedBodies(shed bool) Option         { return func(c *Config) { c.ShedBodies = shed } }
+func WithStore(s Store) Option                { return func(c *Config) { c.Store = s } }
+func WithRuntimeSampling(every int) Option    { return func(c *Config) { c.RuntimeSampling = every } }
+func WithMaxRoutes(n int) Option              { return func(c *Config) { c.MaxRoutes = n } }
+func WithRouteIdleTTL(d time.Duration) Option { return func(c *Config) { c.RouteIdleTTL = d } }
+func WithAlertResolveAfter(d time.Duration) Option {
+	return func(c *Config) { c.AlertResolveAfter = d }
+}
+func WithAlertRetention(d time.Duration) Option { return func(c *Config) { c.AlertRetention = d } }
+func WithMaxAlertGroups(n int) Option           { return func(c *Config) { c.MaxAlertGroups = n } }
+func WithAdaptiveSampling(maxPerSecond float64) Option {
+	return func(c *Config) { c.MaxTracesPerSecond = maxPerSecond }
+}
+func WithSamplingRules(rules ...SamplingRule) Option {
+	return func(c *Config) { c.SamplingRules = append(c.SamplingRules, rules...) }
+}
+func WithRedaction(r RedactionConfig) Option { return func(c *Config) { c.Redaction = r } }
+func WithCaptureContentTypes(types ...string) Option {
+	return func(c *Config) { c.CaptureContentTypes = types }
+}
+func WithSkipContentTypes(types ...string) Option {
+	return func(c *Config) { c.SkipContentTypes = types }
+}
@@ -1,52 +1,132 @@
 package xrayhq
 
-import "context"
+import (
+	"context"
+	"sync"
+)
 
 type contextKey struct{ name string }
 
 var traceKey = &contextKey{"xrayhq-trace"}
 
-func withTrace(ctx context.Context, t *RequestTrace) context.Context {
-	return context.WithValue(ctx, traceKey, t)
+// traceRecorder guards a RequestTrace while its request is in flight.
+// Handlers may fan out goroutines that record operations concurrently, so
+// every mutation goes through the recorder's lock. Once the trace is sealed
+// for recording it is read-only, and operations that finish afterwards are
+// counted as late on the collector instead of being attached.
+type traceRecorder struct {
+	mu            sync.Mutex
+	trace         *RequestTrace
+	collector     *Collector
+	sealed        bool
+	routeResolver RouteResolver
 }
 
+func newTraceRecorder(collector *Collector, t *RequestTrace) *traceRecorder {
+	return &traceRecorder{trace: t, collector: collector}
+}
+
+// mutate applies fn to the trace unless it has already been sealed. It
+// reports whether fn ran.
+func (rec *traceRecorder) mutate(fn func(t *RequestTrace)) bool {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.sealed {
+		return false
+	}
+	fn(rec.trace)
+	return true
+}
+
+// addOp is mutate for operations that count as late when they miss the trace.
+func (rec *traceRecorder) addOp(fn func(t *RequestTrace)) {
+	if !rec.mutate(fn) && rec.collector != nil {
+		rec.collector.lateOps.Add(1)
+	}
+}
+
+// seal stops further mutation. The caller becomes the only writer of the
+// trace and may finalize it without holding the lock.
+func (rec *traceRecorder) seal() {
+	rec.mu.Lock()
+	rec.sealed = true
+	rec.mu.Unlock()
+}
+
+func withRecorder(ctx context.Context, rec *traceRecorder) context.Context {
+	return context.WithValue(ctx, traceKey, rec)
+}
+
+func recorderFromContext(ctx context.Context) *traceRecorder {
+	rec, _ := ctx.Value(traceKey).(*traceRecorder)
+	return rec
+}
+
+// snapshot returns a copy of the trace taken under the lock, or nil once the
+// trace is sealed and may be finalized without it.
+func (rec *traceRecorder) snapshot() *RequestTrace {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.sealed {
+		return nil
+	}
+	return rec.trace.clone()
+}
+
+// TraceFromContext returns a copy of the in-flight trace in ctx as of the
+// call. Changing the copy does not affect the recorded trace. It returns nil
+// outside a traced request and once the request has finished.
 func TraceFromContext(ctx context.Context) *RequestTrace {
-	t, _ := ctx.Value(traceKey).(*RequestTrace)
-	return t
+	if rec := recorderFromContext(ctx); rec != nil {
+		return rec.snapshot()
+	}
+	return nil
 }
 
 func AddDBQuery(ctx context.Context, q DBQuery) {
-	t := TraceFromContext(ctx)
-	if t == nil {
-		return
+	if rec := recorderFromContext(ctx); rec != nil {
+		if q.ParentSpanID == "" {
+			q.ParentSpanID = currentSpanID(ctx)
+		}
+		rec.addOp(func(t *RequestTrace) {
+			t.DBQueries = append(t.DBQueries, q)
+			t.TotalDBTime += q.Duration
+		})
 	}
-	t.DBQueries = append(t.DBQueries, q)
-	t.TotalDBTime += q.Duration
 }
 
 func AddExternalCall(ctx context.Context, c ExternalCall) {
-	t := TraceFromContext(ctx)
-	if t == nil {
-		return
+	if rec := recorderFromContext(ctx); rec != nil {
+		if c.ParentSpanID == "" {
+			c.ParentSpanID = currentSpanID(ctx)
+		}
+		rec.addOp(func(t *RequestTrace) {
+			t.ExternalCalls = append(t.ExternalCalls, c)
+			t.TotalExtTime += c.Duration
+		})
 	}
-	t.ExternalCalls = append(t.ExternalCalls, c)
-	t.TotalExtTime += c.Duration
 }
 
 func AddRedisOp(ctx context.Context, op RedisOp) {
-	t := TraceFromContext(ctx)
-	if t == nil {
-		return
+	if rec := recorderFromContext(ctx); rec != nil {
+		if op.ParentSpanID == "" {
+			op.ParentSpanID = currentSpanID(ctx)
+		}
+		rec.addOp(func(t *RequestTrace) {
+			t.RedisOps = append(t.RedisOps, op)
+			t.TotalRedisTime += op.Duration
+		})
 	}
-	t.RedisOps = append(t.RedisOps, op)
-	t.TotalRedisTime += op.Duration
 }
 
 func AddMongoOp(ctx context.Context, op MongoOp) {
-	t := TraceFromContext(ctx)
-	if t == nil {
-		return
+	if rec := recorderFromContext(ctx); rec != nil {
+		if op.ParentSpanID == "" {
+			op.ParentSpanID = currentSpanID(ctx)
+		}
+		rec.addOp(func(t *RequestTrace) {
+			t.MongoOps = append(t.MongoOps, op)
+			t.TotalMongoTime += op.Duration
+		})
 	}
-	t.MongoOps = append(t.MongoOps, op)
-	t.TotalMongoTime += op.Duration
 }
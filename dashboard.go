@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"net/http"
+	"path"
 	"runtime"
 	"sort"
 	"strings"
@@ -103,9 +105,10 @@ var funcMap = template.FuncMap{
 		}
 		return template.JS(b), nil
 	},
-	"sub": func(a, b int) int { return a - b },
-	"add": func(a, b int) int { return a + b },
-	"mul": func(a, b int) int { return a * b },
+	"routePath": routePath,
+	"sub":       func(a, b int) int { return a - b },
+	"add":       func(a, b int) int { return a + b },
+	"mul":       func(a, b int) int { return a * b },
 	"dict": func(values ...interface{}) map[string]interface{} {
 		d := make(map[string]interface{})
 		for i := 0; i < len(values)-1; i += 2 {
@@ -156,17 +159,28 @@ var funcMap = template.FuncMap{
 type DashboardServer struct {
 	collector *Collector
 	config    *Config
-	templates *template.Template
+	templates map[string]*template.Template
 	mux       *http.ServeMux
+	base      string // DashboardPrefix without a trailing slash
 }
 
+// NewDashboardServer returns an http.Server serving the dashboard for
+// collector on config.Port.
 func NewDashboardServer(collector *Collector, config *Config) *http.Server {
+	return &http.Server{
+		Addr:    config.Port,
+		Handler: newDashboardHandler(collector, config),
+	}
+}
+
+func newDashboardHandler(collector *Collector, config *Config) http.Handler {
 	ds := &DashboardServer{
 		collector: collector,
 		config:    config,
+		base:      dashboardBase(config.DashboardPrefix),
 	}
 
-	tmpl, err := template.New("").Funcs(funcMap).ParseFS(dashboardFS, "dashboard/templates/*.html")
+	tmpl, err := parseTemplates()
 	if err != nil {
 		panic(fmt.Sprintf("xrayhq: failed to parse templates: %v", err))
 	}
@@ -186,21 +200,78 @@ func NewDashboardServer(collector *Collector, config *Config) *http.Server {
 	mux.HandleFunc("/request/", ds.handleRequestDetail)
 	mux.HandleFunc("/live", ds.handleLiveTail)
 	mux.HandleFunc("/alerts", ds.handleAlerts)
+	mux.HandleFunc("/alerts/ack", ds.handleAlertAction(AlertAcknowledged))
+	mux.HandleFunc("/alerts/resolve", ds.handleAlertAction(AlertResolved))
 	mux.HandleFunc("/system", ds.handleSystem)
+	mux.HandleFunc("/search", ds.handleSearch)
 
 	// API endpoints
 	mux.HandleFunc("/events", ds.handleSSE)
 	mux.HandleFunc("/xrayhq/export", ds.handleExport)
+	mux.HandleFunc("/api/search", ds.handleAPISearch)
+	mux.HandleFunc("/api/route/", ds.handleAPIRoute)
+	mux.HandleFunc("/api/alerts", ds.handleAPIAlerts)
+	mux.Handle("/metrics", collector.MetricsHandler())
 
 	var handler http.Handler = mux
+	if ds.base != "" {
+		handler = stripBase(ds.base, mux)
+	}
 	if config.BasicAuthUser != "" && config.BasicAuthPass != "" {
-		handler = basicAuth(config.BasicAuthUser, config.BasicAuthPass, mux)
+		handler = basicAuth(config.BasicAuthUser, config.BasicAuthPass, handler)
 	}
+	return handler
+}
 
-	return &http.Server{
-		Addr:    config.Port,
-		Handler: handler,
+// dashboardBase normalizes a dashboard prefix to "" or a path with a leading
+// and no trailing slash, so templates can build links as {{.Base}}/route/....
+func dashboardBase(prefix string) string {
+	prefix = strings.TrimRight(prefix, "/")
+	if prefix != "" && prefix[0] != '/' {
+		prefix = "/" + prefix
+	}
+	return prefix
+}
+
+// stripBase removes base from request paths before they reach the
+// dashboard's own routes. The bare prefix is redirected to prefix + "/" so
+// relative links resolve the same way as on a standalone dashboard.
+func stripBase(base string, next http.Handler) http.Handler {
+	stripped := http.StripPrefix(base, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == base {
+			target := base + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		stripped.ServeHTTP(w, r)
+	})
+}
+
+// parseTemplates parses each page together with the shared layout. Every
+// page defines its own "content" template, so pages must live in separate
+// template sets or the last one parsed would replace all the others.
+func parseTemplates() (map[string]*template.Template, error) {
+	pages, err := fs.Glob(dashboardFS, "dashboard/templates/*.html")
+	if err != nil {
+		return nil, err
+	}
+	templates := make(map[string]*template.Template, len(pages))
+	for _, page := range pages {
+		name := path.Base(page)
+		if name == "layout.html" {
+			continue
+		}
+		tmpl, err := template.New(name).Funcs(funcMap).ParseFS(dashboardFS, "dashboard/templates/layout.html", page)
+		if err != nil {
+			return nil, err
+		}
+		templates[name] = tmpl
 	}
+	return templates, nil
 }
 
 func basicAuth(user, pass string, next http.Handler) http.Handler {
@@ -245,13 +316,10 @@ func (ds *DashboardServer) handleRoutes(w http.ResponseWriter, r *http.Request)
 		sort.Slice(routes, func(i, j int) bool { return routes[i].ErrorRate() > routes[j].ErrorRate() })
 	}
 
-	alerts := ds.collector.GetAlerts()
-	activeAlerts := len(alerts)
-
 	data := map[string]interface{}{
 		"Routes":       routes,
 		"Sort":         sortBy,
-		"ActiveAlerts": activeAlerts,
+		"ActiveAlerts": ds.collector.ActiveAlertCount(),
 		"RequestCount": ds.collector.RequestCount(),
 		"Page":         "routes",
 	}
@@ -259,15 +327,11 @@ func (ds *DashboardServer) handleRoutes(w http.ResponseWriter, r *http.Request)
 }
 
 func (ds *DashboardServer) handleRouteDetail(w http.ResponseWriter, r *http.Request) {
-	// Parse /route/METHOD/pattern
-	path := strings.TrimPrefix(r.URL.Path, "/route/")
-	parts := strings.SplitN(path, "/", 2)
-	if len(parts) < 2 {
+	method, pattern, ok := parseRoutePath(strings.TrimPrefix(r.URL.Path, "/route/"))
+	if !ok {
 		http.NotFound(w, r)
 		return
 	}
-	method := parts[0]
-	pattern := "/" + parts[1]
 
 	rm := ds.collector.GetRoute(method, pattern)
 	if rm == nil {
@@ -298,7 +362,11 @@ func (ds *DashboardServer) handleRouteDetail(w http.ResponseWriter, r *http.Requ
 	})
 
 	// Latency distribution for histogram
-	latencyBuckets := computeLatencyBuckets(rm.Latencies)
+	latencyBuckets := computeLatencyBuckets(rm.Latency)
+
+	windowLabel, window := parseRouteWindow(r.URL.Query().Get("window"))
+	until := ds.collector.now()
+	since := until.Add(-window)
 
 	data := map[string]interface{}{
 		"Route":           rm,
@@ -306,13 +374,17 @@ func (ds *DashboardServer) handleRouteDetail(w http.ResponseWriter, r *http.Requ
 		"SlowestRequests": slowest,
 		"StatusDist":      statusDist,
 		"LatencyBuckets":  latencyBuckets,
+		"Window":          ds.collector.RouteWindow(method, pattern, since, until),
+		"WindowLabel":     windowLabel,
+		"Windows":         routeWindows,
+		"Series":          seriesPoints(ds.collector.RouteSeries(method, pattern, since, until)),
 		"Page":            "route_detail",
 	}
 	ds.render(w, "route_detail.html", data)
 }
 
-func computeLatencyBuckets(latencies []time.Duration) []map[string]interface{} {
-	if len(latencies) == 0 {
+func computeLatencyBuckets(sketch *LatencySketch) []map[string]interface{} {
+	if sketch.Count() == 0 {
 		return nil
 	}
 	bucketLabels := []string{"<1ms", "1-5ms", "5-10ms", "10-50ms", "50-100ms", "100-500ms", "500ms-1s", ">1s"}
@@ -325,20 +397,14 @@ func computeLatencyBuckets(latencies []time.Duration) []map[string]interface{} {
 		500 * time.Millisecond,
 		time.Second,
 	}
-	counts := make([]int, len(bucketLabels))
-	for _, l := range latencies {
-		placed := false
-		for i, threshold := range bucketThresholds {
-			if l < threshold {
-				counts[i]++
-				placed = true
-				break
-			}
-		}
-		if !placed {
-			counts[len(counts)-1]++
-		}
+	counts := make([]uint64, len(bucketLabels))
+	var below uint64
+	for i, threshold := range bucketThresholds {
+		n := sketch.CountBelow(threshold)
+		counts[i] = n - below
+		below = n
 	}
+	counts[len(counts)-1] = sketch.Count() - below
 	result := make([]map[string]interface{}, len(bucketLabels))
 	for i, label := range bucketLabels {
 		result[i] = map[string]interface{}{
@@ -358,8 +424,9 @@ func (ds *DashboardServer) handleRequestDetail(w http.ResponseWriter, r *http.Re
 	}
 
 	data := map[string]interface{}{
-		"Trace": trace,
-		"Page":  "request_detail",
+		"Trace":     trace,
+		"Waterfall": buildWaterfall(trace),
+		"Page":      "request_detail",
 	}
 	ds.render(w, "request_detail.html", data)
 }
@@ -372,15 +439,18 @@ func (ds *DashboardServer) handleLiveTail(w http.ResponseWriter, r *http.Request
 }
 
 func (ds *DashboardServer) handleAlerts(w http.ResponseWriter, r *http.Request) {
-	alerts := ds.collector.GetAlerts()
-	// Reverse to show newest first
-	for i, j := 0, len(alerts)-1; i < j; i, j = i+1, j-1 {
-		alerts[i], alerts[j] = alerts[j], alerts[i]
+	groups := ds.collector.AlertGroups()
+	active := 0
+	for _, g := range groups {
+		if g.Active() {
+			active++
+		}
 	}
 
 	data := map[string]interface{}{
-		"Alerts": alerts,
-		"Page":   "alerts",
+		"Groups":       groups,
+		"ActiveAlerts": active,
+		"Page":         "alerts",
 	}
 	ds.render(w, "alerts.html", data)
 }
@@ -389,23 +459,64 @@ func (ds *DashboardServer) handleSystem(w http.ResponseWriter, r *http.Request)
 	var mem runtime.MemStats
 	runtime.ReadMemStats(&mem)
 
+	var bufferBytes, bufferBudget int64
+	if ms, ok := ds.collector.Store().(*memoryStore); ok {
+		bufferBytes, bufferBudget = ms.Usage()
+	}
+
 	data := map[string]interface{}{
-		"Goroutines":    runtime.NumGoroutine(),
-		"MemAlloc":      mem.Alloc,
-		"MemTotalAlloc": mem.TotalAlloc,
-		"MemSys":        mem.Sys,
-		"NumGC":         mem.NumGC,
-		"LastGC":        time.Unix(0, int64(mem.LastGC)),
-		"Uptime":        ds.collector.Uptime(),
-		"RequestCount":  ds.collector.RequestCount(),
-		"RouteCount":    len(ds.collector.GetRoutes()),
-		"BufferSize":    ds.config.BufferSize,
-		"Mode":          ds.config.Mode,
-		"Page":          "system",
+		"Goroutines":      runtime.NumGoroutine(),
+		"MemAlloc":        mem.Alloc,
+		"MemTotalAlloc":   mem.TotalAlloc,
+		"MemSys":          mem.Sys,
+		"NumGC":           mem.NumGC,
+		"LastGC":          time.Unix(0, int64(mem.LastGC)),
+		"Uptime":          ds.collector.Uptime(),
+		"RequestCount":    ds.collector.RequestCount(),
+		"RouteCount":      ds.collector.RouteCount(),
+		"MaxRoutes":       ds.config.MaxRoutes,
+		"CollapsedRoutes": ds.collector.CollapsedRoutes(),
+		"EvictedRoutes":   ds.collector.EvictedRoutes(),
+		"LateOps":         ds.collector.LateOps(),
+		"SampledOut":      ds.collector.SampledOut(),
+		"SamplingMode":    ds.config.SamplingMode,
+		"SamplingPercent": ds.config.SamplingRate * 100,
+		"Storage":         storeDescription(ds.collector.Store()),
+		"StoreErrors":     ds.collector.StoreErrors(),
+		"OTLPEnabled":     ds.collector.exporter != nil,
+		"ExportDropped":   ds.collector.ExportDropped(),
+		"ExportFailed":    ds.collector.ExportFailed(),
+		"BufferBytes":     bufferBytes,
+		"BufferBudget":    bufferBudget,
+		"BufferPercent":   percentOf(bufferBytes, bufferBudget),
+		"Mode":            ds.config.Mode,
+		"Page":            "system",
 	}
 	ds.render(w, "system.html", data)
 }
 
+// storeDescription describes where traces are kept, for the system page.
+func storeDescription(s Store) string {
+	switch s := s.(type) {
+	case *memoryStore:
+		if s.opts.MaxTraces > 0 {
+			return fmt.Sprintf("memory, up to %d requests", s.opts.MaxTraces)
+		}
+		return "memory"
+	case *DiskStore:
+		return fmt.Sprintf("disk, %s in %s", formatBytes(uint64(s.Size())), s.Dir())
+	default:
+		return fmt.Sprintf("%T", s)
+	}
+}
+
+func percentOf(n, total int64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return float64(n) / float64(total) * 100
+}
+
 func (ds *DashboardServer) handleSSE(w http.ResponseWriter, r *http.Request) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
@@ -416,6 +527,10 @@ func (ds *DashboardServer) handleSSE(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
+	// Send the headers now so clients see the stream open before the first
+	// request is recorded.
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
 
 	ch := ds.collector.SubscribeSSE()
 	defer ds.collector.UnsubscribeSSE(ch)
@@ -450,9 +565,15 @@ func (ds *DashboardServer) handleSSE(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (ds *DashboardServer) render(w http.ResponseWriter, name string, data interface{}) {
+func (ds *DashboardServer) render(w http.ResponseWriter, name string, data map[string]interface{}) {
+	data["Base"] = ds.base
+	tmpl, ok := ds.templates[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Template error: unknown page %q", name), http.StatusInternalServerError)
+		return
+	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	if err := ds.templates.ExecuteTemplate(w, name, data); err != nil {
+	if err := tmpl.ExecuteTemplate(w, name, data); err != nil {
 		http.Error(w, fmt.Sprintf("Template error: %v", err), http.StatusInternalServerError)
 	}
 }
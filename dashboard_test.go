@@ -0,0 +1,174 @@
+package xrayhq
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboardRequestDetailWaterfall(t *testing.T) {
+	c, cfg := setupTestCollector()
+	start := time.Now()
+	c.Record(&RequestTrace{
+		ID:             "detail-1",
+		Method:         "GET",
+		Path:           "/api/orders",
+		RoutePattern:   "/api/orders",
+		ResponseStatus: 200,
+		StartTime:      start,
+		Latency:        10 * time.Millisecond,
+		Spans: []*Span{
+			{ID: "s1", Name: "render template", StartTime: start, Duration: 5 * time.Millisecond},
+		},
+	})
+
+	srv := NewDashboardServer(c, cfg)
+	rec := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, httptest.NewRequest("GET", "/request/detail-1", nil))
+
+	if rec.Code != 200 {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "Request Detail") {
+		t.Error("expected request detail page")
+	}
+	if !strings.Contains(body, "render template") || !strings.Contains(body, "bar-span") {
+		t.Error("expected span in waterfall")
+	}
+}
+
+func TestDashboardMountedUnderPrefix(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DashboardPrefix = "/debug/xrayhq/"
+	c := NewCollector(cfg)
+	c.Record(&RequestTrace{
+		ID:             "prefixed-1",
+		Method:         "GET",
+		Path:           "/api/users",
+		RoutePattern:   "/api/users",
+		ResponseStatus: 200,
+		StartTime:      time.Now(),
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/xrayhq/", newDashboardHandler(c, cfg))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	rec := get("/debug/xrayhq/")
+	if rec.Code != 200 {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, link := range []string{
+		`href="/debug/xrayhq/dashboard/static/style.css"`,
+		`href="/debug/xrayhq/live"`,
+		`href="/debug/xrayhq/xrayhq/export?format=json"`,
+		`'\/debug\/xrayhq/route/GET\/api\/users'`,
+	} {
+		if !strings.Contains(body, link) {
+			t.Errorf("expected %s in routes page", link)
+		}
+	}
+
+	rec = get("/debug/xrayhq/live")
+	if !strings.Contains(rec.Body.String(), `EventSource('\/debug\/xrayhq/events')`) {
+		t.Error("expected live tail to subscribe under the prefix")
+	}
+
+	for _, path := range []string{
+		"/debug/xrayhq/route/GET/api/users",
+		"/debug/xrayhq/request/prefixed-1",
+		"/debug/xrayhq/dashboard/static/style.css",
+		"/debug/xrayhq/xrayhq/export?format=csv",
+	} {
+		if rec := get(path); rec.Code != 200 {
+			t.Errorf("%s: expected 200, got %d", path, rec.Code)
+		}
+	}
+
+	// Without a trailing slash in the mount, the handler redirects the bare
+	// prefix itself.
+	rec = httptest.NewRecorder()
+	newDashboardHandler(c, cfg).ServeHTTP(rec, httptest.NewRequest("GET", "/debug/xrayhq", nil))
+	if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "/debug/xrayhq/" {
+		t.Errorf("expected redirect to the prefix root, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+}
+
+func TestOverflowRouteDetailPage(t *testing.T) {
+	c, cfg := setupTestCollector()
+	cfg.MaxRoutes = 1
+	c.Record(&RequestTrace{Method: "GET", Path: "/a", RoutePattern: "/a", ResponseStatus: 200, StartTime: time.Now()})
+	c.Record(&RequestTrace{ID: "o1", Method: "GET", Path: "/wp-login.php", RoutePattern: "/wp-login.php", ResponseStatus: 404, StartTime: time.Now()})
+	handler := newDashboardHandler(c, cfg)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(rec.Body.String(), `/route/GET\/\u003cother\u003e`) {
+		t.Error("expected the routes page to link to the overflow route")
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/route/GET/%3Cother%3E", nil))
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), "/request/o1") {
+		t.Errorf("expected the overflow route detail page, got %d", rec.Code)
+	}
+}
+
+func TestDashboardAlertActions(t *testing.T) {
+	c, cfg := setupTestCollector()
+	c.AddAlert(Alert{Type: "panic", Message: "boom", Severity: SeverityCritical, RoutePattern: "/x"})
+	fp := c.AlertGroups()[0].Fingerprint
+	handler := newDashboardHandler(c, cfg)
+
+	post := func(path, fingerprint, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader("fingerprint="+fingerprint))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/alerts", nil))
+	if !strings.Contains(rec.Body.String(), "Acknowledge") {
+		t.Error("expected an acknowledge button for a firing alert")
+	}
+
+	if rec := post("/alerts/ack", fp, "text/html"); rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/alerts" {
+		t.Errorf("expected a redirect back to the alerts page, got %d", rec.Code)
+	}
+	if g, _ := c.AlertGroup(fp); g.State != AlertAcknowledged {
+		t.Errorf("expected acknowledged, got %s", g.State)
+	}
+	if rec := post("/alerts/ack", fp, "text/html"); rec.Code != http.StatusConflict {
+		t.Errorf("expected a conflict acknowledging twice, got %d", rec.Code)
+	}
+	if rec := post("/alerts/resolve", "nope", "application/json"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown fingerprint, got %d", rec.Code)
+	}
+	if rec := post("/alerts/resolve", fp, "application/json"); rec.Code != 200 || !strings.Contains(rec.Body.String(), `"State":"resolved"`) {
+		t.Errorf("expected the resolved group as JSON, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/alerts/ack", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected GET to be rejected, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/alerts?state=firing", nil))
+	if strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Errorf("expected no firing alerts, got %s", rec.Body.String())
+	}
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/Bhavyyadav25/xrayhq"
@@ -13,7 +17,7 @@ import (
 
 func main() {
 	// Initialize xrayhq with configuration options
-	xrayhq.Init(
+	x, err := xrayhq.Init(
 		xrayhq.WithPort(":9090"),
 		xrayhq.WithBufferSize(1000),
 		xrayhq.WithMode(xrayhq.ModeDev),
@@ -27,6 +31,9 @@ func main() {
 		xrayhq.WithSamplingRate(1.0),
 		// xrayhq.WithBasicAuth("admin", "secret"),
 	)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Wrap an HTTP client to track external calls
 	client := xrayhq.WrapHTTPClient(&http.Client{Timeout: 5 * time.Second})
@@ -120,11 +127,28 @@ func main() {
 	})
 
 	// Wrap with xrayhq middleware
-	handler := xrayhq.Wrap(mux)
+	srv := &http.Server{Addr: ":8080", Handler: x.Middleware(mux)}
 
-	log.Println("API server starting on :8080")
-	log.Println("xrayhq dashboard at http://localhost:9090")
-	log.Fatal(http.ListenAndServe(":8080", handler))
+	go func() {
+		log.Println("API server starting on :8080")
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// Stop the API server first, then the dashboard, on Ctrl+C or SIGTERM.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("API server shutdown: %v", err)
+	}
+	if err := x.Shutdown(shutdownCtx); err != nil {
+		log.Printf("xrayhq shutdown: %v", err)
+	}
 }
 
 // Additional integration examples (not wired into this demo server):
@@ -4,29 +4,46 @@ import (
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 )
 
+// handleExport streams every stored trace, newest first, without copying
+// the buffer.
 func (ds *DashboardServer) handleExport(w http.ResponseWriter, r *http.Request) {
 	format := r.URL.Query().Get("format")
-	requests := ds.collector.GetAllRequests()
 
 	switch format {
 	case "csv":
-		ds.exportCSV(w, requests)
+		ds.exportCSV(w)
 	default:
-		ds.exportJSON(w, requests)
+		ds.exportJSON(w)
 	}
 }
 
-func (ds *DashboardServer) exportJSON(w http.ResponseWriter, requests []*RequestTrace) {
+func (ds *DashboardServer) exportJSON(w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Content-Disposition", "attachment; filename=xrayhq-export.json")
-	json.NewEncoder(w).Encode(requests)
+
+	io.WriteString(w, "[")
+	first := true
+	ds.collector.Iterate(TraceFilter{}, func(req *RequestTrace) bool {
+		b, err := json.Marshal(req)
+		if err != nil {
+			return true
+		}
+		if !first {
+			io.WriteString(w, ",")
+		}
+		first = false
+		_, err = w.Write(b)
+		return err == nil
+	})
+	io.WriteString(w, "]\n")
 }
 
-func (ds *DashboardServer) exportCSV(w http.ResponseWriter, requests []*RequestTrace) {
+func (ds *DashboardServer) exportCSV(w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "text/csv")
 	w.Header().Set("Content-Disposition", "attachment; filename=xrayhq-export.csv")
 
@@ -41,7 +58,7 @@ func (ds *DashboardServer) exportCSV(w http.ResponseWriter, requests []*RequestT
 		"ClientIP", "UserAgent", "Timestamp", "Panicked",
 	})
 
-	for _, req := range requests {
+	ds.collector.Iterate(TraceFilter{}, func(req *RequestTrace) bool {
 		writer.Write([]string{
 			req.ID,
 			req.Method,
@@ -61,5 +78,6 @@ func (ds *DashboardServer) exportCSV(w http.ResponseWriter, requests []*RequestT
 			req.StartTime.Format("2006-01-02T15:04:05.000Z07:00"),
 			strconv.FormatBool(req.Panicked),
 		})
-	}
+		return writer.Error() == nil
+	})
 }
@@ -25,6 +25,23 @@ func WrapHTTPClient(client *http.Client) *http.Client {
 }
 
 func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Propagate the trace to the callee as a child span, unless the caller
+	// already set its own traceparent. RoundTrippers must not modify the
+	// request, so headers are set on a shallow copy.
+	var spanID string
+	if rec := recorderFromContext(req.Context()); rec != nil && req.Header.Get(traceparentHeader) == "" {
+		spanID = generateSpanID()
+		outReq := *req
+		outReq.Header = req.Header.Clone()
+		if outReq.Header == nil {
+			outReq.Header = make(http.Header)
+		}
+		// The trace context fields are set before the handler runs and
+		// never change, so they can be read without the recorder's lock.
+		injectTraceContext(outReq.Header, rec.trace, spanID)
+		req = &outReq
+	}
+
 	start := time.Now()
 	resp, err := t.base.RoundTrip(req)
 	duration := time.Since(start)
@@ -34,6 +51,7 @@ func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, er
 		Method:    req.Method,
 		Duration:  duration,
 		Timestamp: start,
+		SpanID:    spanID,
 	}
 	if err != nil {
 		call.Error = err.Error()
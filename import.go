@@ -0,0 +1,126 @@
+package xrayhq
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// ReadTraces decodes traces from r: either a JSON array as written by the
+// dashboard's JSON export, or one JSON object per line as in DiskStore
+// segments. A partial last line, left by a crash mid-write, is skipped.
+func ReadTraces(r io.Reader) ([]*RequestTrace, error) {
+	br := bufio.NewReader(r)
+	first, err := peekNonSpace(br)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var traces []*RequestTrace
+	if first == '[' {
+		if err := json.NewDecoder(br).Decode(&traces); err != nil {
+			return nil, fmt.Errorf("xrayhq: decoding trace array: %w", err)
+		}
+		return traces, nil
+	}
+	for n := 1; ; n++ {
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return traces, err
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			t := new(RequestTrace)
+			if jerr := json.Unmarshal(line, t); jerr != nil {
+				if err == io.EOF {
+					// No newline: the writer stopped mid-line.
+					return traces, nil
+				}
+				return traces, fmt.Errorf("xrayhq: decoding trace on line %d: %w", n, jerr)
+			}
+			traces = append(traces, t)
+		}
+		if err == io.EOF {
+			return traces, nil
+		}
+	}
+}
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b, br.UnreadByte()
+	}
+}
+
+// Import replays previously recorded traces, oldest first, rebuilding route
+// metrics and alert groups as if the requests had just been served. Traces
+// whose ID is already stored are skipped. Sampling, redaction, export and
+// live tail subscribers are bypassed.
+//
+// Import pins the collector's clock to the end of the latest imported
+// trace, so route windows, searches and alert states show the snapshot as
+// of when it was taken. It is meant for a collector dedicated to viewing
+// exported traces and must not run concurrently with other calls on it.
+func (c *Collector) Import(traces []*RequestTrace) (int, error) {
+	sorted := make([]*RequestTrace, len(traces))
+	copy(sorted, traces)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].StartTime.Before(sorted[j].StartTime) })
+
+	c.now = func() time.Time { return c.importedUntil }
+	n := 0
+	for _, t := range sorted {
+		if t.ID != "" {
+			if existing, _ := c.store.Get(t.ID); existing != nil {
+				continue
+			}
+		}
+		if end := t.StartTime.Add(t.Latency); end.After(c.importedUntil) {
+			c.importedUntil = end
+		}
+		if t.SampleRate <= 0 {
+			t.SampleRate = 1
+		}
+		// Alerts are evaluated again, against the rebuilt metrics.
+		t.Alerts = nil
+
+		c.mu.Lock()
+		c.routeFor(t).Record(t)
+		c.mu.Unlock()
+		c.alertEngine.Evaluate(t)
+
+		if err := c.store.Append(t); err != nil {
+			c.storeErrors.Add(1)
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// rebuildRoutes counts the traces already in the store in route metrics, so
+// a DiskStore's routes are not empty after a restart. Only stored traces
+// are counted, weighted by their SampleRate; alerts are not rebuilt.
+func (c *Collector) rebuildRoutes() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.store.Iterate(TraceFilter{}, func(t *RequestTrace) bool {
+		// routeFor may rewrite the route, and stored traces must not change.
+		cp := *t
+		c.routeFor(&cp).Record(&cp)
+		return true
+	})
+}
@@ -0,0 +1,127 @@
+package xrayhq
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestImportExportedTraces(t *testing.T) {
+	src, cfg := setupTestCollector()
+	start := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	for i := 0; i < 20; i++ {
+		tr := slowQueryTrace("/orders", "SELECT * FROM orders")
+		tr.ID = "trace-" + string(rune('a'+i))
+		tr.Path = "/orders"
+		tr.ResponseStatus = 200
+		tr.Latency = 10 * time.Millisecond
+		tr.StartTime = start.Add(time.Duration(i) * time.Second)
+		src.Record(tr)
+	}
+
+	ds := &DashboardServer{collector: src, config: cfg}
+	rec := httptest.NewRecorder()
+	ds.handleExport(rec, httptest.NewRequest("GET", "/xrayhq/export?format=json", nil))
+	traces, err := ReadTraces(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(traces) != 20 {
+		t.Fatalf("expected 20 traces read, got %d", len(traces))
+	}
+
+	c := NewCollector(DefaultConfig())
+	if n, err := c.Import(traces); err != nil || n != 20 {
+		t.Fatalf("expected 20 traces imported, got %d, %v", n, err)
+	}
+	if want := start.Add(19*time.Second + 10*time.Millisecond); !c.now().Equal(want) {
+		t.Errorf("expected the clock pinned to %v, got %v", want, c.now())
+	}
+	rm := c.GetRoute("GET", "/orders")
+	if rm == nil || rm.TotalRequests != 20 {
+		t.Fatalf("expected route metrics rebuilt, got %+v", rm)
+	}
+	if w := rm.Recent(); w.TotalRequests != 20 {
+		t.Errorf("expected the recent window relative to the snapshot, got %d requests", w.TotalRequests)
+	}
+	groups := c.AlertGroups()
+	if len(groups) != 1 || groups[0].Count != 20 || groups[0].State != AlertFiring {
+		t.Errorf("expected one firing alert group rebuilt, got %+v", groups)
+	}
+
+	if n, _ := c.Import(traces); n != 0 {
+		t.Errorf("expected traces already imported to be skipped, got %d", n)
+	}
+	if rm := c.GetRoute("GET", "/orders"); rm.TotalRequests != 20 {
+		t.Errorf("expected no double counting, got %d requests", rm.TotalRequests)
+	}
+}
+
+func TestReadTracesNDJSON(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewDiskStore(dir, DiskStoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		s.Append(storeTrace(i, start))
+	}
+	s.Close()
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "segment-*.ndjson"))
+	if len(segments) == 0 {
+		t.Fatal("expected a segment file")
+	}
+	f, err := os.Open(segments[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	traces, err := ReadTraces(f)
+	if err != nil || len(traces) != 5 {
+		t.Fatalf("expected 5 traces, got %d, %v", len(traces), err)
+	}
+
+	if traces, err := ReadTraces(bytes.NewReader(nil)); err != nil || len(traces) != 0 {
+		t.Errorf("expected no traces from empty input, got %d, %v", len(traces), err)
+	}
+	if _, err := ReadTraces(bytes.NewBufferString("{\"ID\":\"a\"}\nnot json\n")); err == nil {
+		t.Error("expected an error for malformed input")
+	}
+	if traces, err := ReadTraces(bytes.NewBufferString("{\"ID\":\"a\"}")); err != nil || len(traces) != 1 {
+		t.Errorf("expected a complete last line without newline to be read, got %d, %v", len(traces), err)
+	}
+}
+
+func TestReadTracesSkipsPartialLastLine(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewDiskStore(dir, DiskStoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		s.Append(storeTrace(i, start))
+	}
+	s.Close()
+
+	// Simulate a crash in the middle of writing a trace.
+	segments, _ := filepath.Glob(filepath.Join(dir, "segment-*.ndjson"))
+	f, err := os.OpenFile(segments[0], os.O_APPEND|os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.WriteString(`{"ID":"partial","Meth`)
+	f.Seek(0, io.SeekStart)
+
+	traces, err := ReadTraces(f)
+	if err != nil || len(traces) != 3 {
+		t.Fatalf("expected 3 traces before the partial line, got %d, %v", len(traces), err)
+	}
+}
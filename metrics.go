@@ -1,7 +1,8 @@
 package xrayhq
 
 import (
-	"sort"
+	"container/list"
+	"math"
 	"time"
 )
 
@@ -11,37 +12,79 @@ type RouteMetrics struct {
 	TotalRequests int64
 	ErrorCount    int64 // 5xx responses
 	TotalLatency  time.Duration
-	Latencies     []time.Duration // for percentile calculation - capped at 10000
+	Latency       *LatencySketch // for percentiles, see DefaultSketchAccuracy
 
-	StatusCodes   map[int]int64
-	AvgDBQueries  float64
-	totalDBQueries int64
+	StatusCodes  map[int]int64
+	AvgDBQueries float64
 
 	MinLatency time.Duration
 	MaxLatency time.Duration
 
+	// Dependency calls and the time spent in them, across all requests.
+	DBQueries     int64
+	DBTime        time.Duration
+	RedisOps      int64
+	RedisTime     time.Duration
+	MongoOps      int64
+	MongoTime     time.Duration
+	ExternalCalls int64
+	ExternalTime  time.Duration
+
 	LastRequestTime time.Time
-	latencyCap      int
+
+	// EstimatedRequests and EstimatedErrors extrapolate the counts above to
+	// all traffic, weighting each request by the inverse of its SampleRate.
+	EstimatedRequests float64
+	EstimatedErrors   float64
+
+	rollups *routeRollups
+	recent  *RouteWindow
+
+	// lastSeen and lru track use for idle route eviction.
+	lastSeen time.Time
+	lru      *list.Element
 }
 
+// NewRouteMetrics returns empty metrics for a route.
+//
+// Deprecated: latencyCap is ignored; percentiles come from a LatencySketch,
+// which has bounded memory without a cap.
 func NewRouteMetrics(pattern, method string, latencyCap int) *RouteMetrics {
-	if latencyCap <= 0 {
-		latencyCap = 10000
-	}
+	return newRouteMetrics(pattern, method)
+}
+
+func newRouteMetrics(pattern, method string) *RouteMetrics {
 	return &RouteMetrics{
 		Pattern:     pattern,
 		Method:      method,
 		StatusCodes: make(map[int]int64),
-		Latencies:   make([]time.Duration, 0, 1000),
+		Latency:     NewLatencySketch(DefaultSketchAccuracy),
 		MinLatency:  time.Duration(1<<63 - 1),
-		latencyCap:  latencyCap,
+		rollups:     &routeRollups{},
 	}
 }
 
+// Extrapolated reports whether sampling makes the estimated request count
+// differ from TotalRequests.
+func (rm *RouteMetrics) Extrapolated() bool {
+	return math.Abs(rm.EstimatedRequests-float64(rm.TotalRequests)) >= 0.5
+}
+
 func (rm *RouteMetrics) Record(trace *RequestTrace) {
+	weight := 1.0
+	if trace.SampleRate > 0 {
+		weight = 1 / trace.SampleRate
+	}
+	rm.EstimatedRequests += weight
+	if rm.rollups != nil {
+		rm.rollups.record(trace, weight)
+	}
+
 	rm.TotalRequests++
 	rm.TotalLatency += trace.Latency
-	rm.LastRequestTime = trace.StartTime
+	if trace.StartTime.After(rm.LastRequestTime) {
+		rm.LastRequestTime = trace.StartTime
+	}
 
 	if trace.Latency < rm.MinLatency {
 		rm.MinLatency = trace.Latency
@@ -52,18 +95,21 @@ func (rm *RouteMetrics) Record(trace *RequestTrace) {
 
 	if trace.ResponseStatus >= 500 {
 		rm.ErrorCount++
+		rm.EstimatedErrors += weight
 	}
 
 	rm.StatusCodes[trace.ResponseStatus]++
-	rm.totalDBQueries += int64(len(trace.DBQueries))
-	if rm.TotalRequests > 0 {
-		rm.AvgDBQueries = float64(rm.totalDBQueries) / float64(rm.TotalRequests)
-	}
-
-	// Keep latencies for percentile calc
-	if len(rm.Latencies) < rm.latencyCap {
-		rm.Latencies = append(rm.Latencies, trace.Latency)
-	}
+	rm.DBQueries += int64(len(trace.DBQueries))
+	rm.AvgDBQueries = float64(rm.DBQueries) / float64(rm.TotalRequests)
+	rm.DBTime += trace.TotalDBTime
+	rm.RedisOps += int64(len(trace.RedisOps))
+	rm.RedisTime += trace.TotalRedisTime
+	rm.MongoOps += int64(len(trace.MongoOps))
+	rm.MongoTime += trace.TotalMongoTime
+	rm.ExternalCalls += int64(len(trace.ExternalCalls))
+	rm.ExternalTime += trace.TotalExtTime
+
+	rm.Latency.Add(trace.Latency)
 }
 
 func (rm *RouteMetrics) AvgLatency() time.Duration {
@@ -80,25 +126,47 @@ func (rm *RouteMetrics) ErrorRate() float64 {
 	return float64(rm.ErrorCount) / float64(rm.TotalRequests) * 100
 }
 
+// Percentile returns the latency at percentile p (0-100), within
+// DefaultSketchAccuracy of the exact value.
 func (rm *RouteMetrics) Percentile(p float64) time.Duration {
-	if len(rm.Latencies) == 0 {
-		return 0
-	}
-	sorted := make([]time.Duration, len(rm.Latencies))
-	copy(sorted, rm.Latencies)
-	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return rm.Latency.Quantile(p / 100)
+}
 
-	idx := int(float64(len(sorted)-1) * p / 100.0)
-	return sorted[idx]
+func (rm *RouteMetrics) P50() time.Duration { return rm.Percentile(50) }
+func (rm *RouteMetrics) P95() time.Duration { return rm.Percentile(95) }
+func (rm *RouteMetrics) P99() time.Duration { return rm.Percentile(99) }
+
+// Recent returns the route's metrics over the last few minutes, so health
+// reflects current behaviour rather than all traffic since start.
+func (rm *RouteMetrics) Recent() *RouteWindow {
+	return rm.recentAt(time.Now())
 }
 
-func (rm *RouteMetrics) P50() time.Duration  { return rm.Percentile(50) }
-func (rm *RouteMetrics) P95() time.Duration  { return rm.Percentile(95) }
-func (rm *RouteMetrics) P99() time.Duration  { return rm.Percentile(99) }
+func (rm *RouteMetrics) recentAt(now time.Time) *RouteWindow {
+	if rm.recent != nil || rm.rollups == nil {
+		return rm.recent
+	}
+	return rm.rollups.window(rm.Method, rm.Pattern, now.Add(-recentWindow), now)
+}
 
+// Window returns the route's metrics over [since, until).
+func (rm *RouteMetrics) Window(since, until time.Time) *RouteWindow {
+	if rm.rollups == nil {
+		return &RouteWindow{Method: rm.Method, Pattern: rm.Pattern, Since: since, Until: until}
+	}
+	return rm.rollups.window(rm.Method, rm.Pattern, since, until)
+}
+
+// Status judges the route on its recent window, falling back to all-time
+// numbers when it has had no traffic lately.
 func (rm *RouteMetrics) Status() string {
-	errRate := rm.ErrorRate()
-	p95 := rm.P95()
+	if w := rm.Recent(); w != nil && w.TotalRequests > 0 {
+		return w.Status()
+	}
+	return routeStatus(rm.ErrorRate(), rm.P95())
+}
+
+func routeStatus(errRate float64, p95 time.Duration) string {
 	if errRate > 10 || p95 > 2*time.Second {
 		return "critical"
 	}
@@ -109,24 +177,41 @@ func (rm *RouteMetrics) Status() string {
 }
 
 func (rm *RouteMetrics) Snapshot() *RouteMetrics {
+	return rm.snapshotAt(time.Now())
+}
+
+func (rm *RouteMetrics) snapshotAt(now time.Time) *RouteMetrics {
 	snap := &RouteMetrics{
-		Pattern:        rm.Pattern,
-		Method:         rm.Method,
-		TotalRequests:  rm.TotalRequests,
-		ErrorCount:     rm.ErrorCount,
-		TotalLatency:   rm.TotalLatency,
-		StatusCodes:    make(map[int]int64),
-		AvgDBQueries:   rm.AvgDBQueries,
-		totalDBQueries: rm.totalDBQueries,
+		Pattern:         rm.Pattern,
+		Method:          rm.Method,
+		TotalRequests:   rm.TotalRequests,
+		ErrorCount:      rm.ErrorCount,
+		TotalLatency:    rm.TotalLatency,
+		StatusCodes:     make(map[int]int64),
+		AvgDBQueries:    rm.AvgDBQueries,
 		MinLatency:      rm.MinLatency,
 		MaxLatency:      rm.MaxLatency,
 		LastRequestTime: rm.LastRequestTime,
-		latencyCap:      rm.latencyCap,
+		Latency:         rm.Latency.Copy(),
+
+		DBQueries:     rm.DBQueries,
+		DBTime:        rm.DBTime,
+		RedisOps:      rm.RedisOps,
+		RedisTime:     rm.RedisTime,
+		MongoOps:      rm.MongoOps,
+		MongoTime:     rm.MongoTime,
+		ExternalCalls: rm.ExternalCalls,
+		ExternalTime:  rm.ExternalTime,
+
+		EstimatedRequests: rm.EstimatedRequests,
+		EstimatedErrors:   rm.EstimatedErrors,
+
+		// Snapshots don't share the live rollups; they keep the recent
+		// window as of now.
+		recent: rm.recentAt(now),
 	}
 	for k, v := range rm.StatusCodes {
 		snap.StatusCodes[k] = v
 	}
-	snap.Latencies = make([]time.Duration, len(rm.Latencies))
-	copy(snap.Latencies, rm.Latencies)
 	return snap
 }
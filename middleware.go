@@ -1,10 +1,8 @@
 package xrayhq
 
 import (
-	"bytes"
 	"crypto/rand"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
 	"runtime"
@@ -18,40 +16,37 @@ func generateID() string {
 	return fmt.Sprintf("%x", b)
 }
 
+// generateSpanID returns a random 8-byte ID in the W3C span-id format.
+func generateSpanID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
+
 // coreMiddleware is the shared middleware logic used by all framework adapters.
 func coreMiddleware(collector *Collector, cfg *Config, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Sampling
-		if cfg.SamplingRate < 1.0 {
-			b := make([]byte, 1)
-			_, _ = rand.Read(b)
-			if float64(b[0])/255.0 > cfg.SamplingRate {
-				next.ServeHTTP(w, r)
-				return
-			}
+		plan := planSampling(cfg, r.Method, r.URL.Path, r.Header.Get)
+		if !plan.trace {
+			next.ServeHTTP(w, r)
+			return
 		}
 
 		start := time.Now()
 
-		// Runtime stats before
-		var memBefore runtime.MemStats
-		runtime.ReadMemStats(&memBefore)
-		goroutinesBefore := runtime.NumGoroutine()
-
-		// Read request body if configured
-		var reqBody []byte
-		if cfg.CaptureBody && r.Body != nil {
-			var err error
-			reqBody, err = io.ReadAll(r.Body)
-			if err != nil {
-				reqBody = nil
-			}
-			r.Body = io.NopCloser(bytes.NewReader(reqBody))
+		stats := collector.beginRequest()
+
+		// Capture the request body as the handler reads it
+		var reqBody *captureReader
+		if plan.captureBody && r.Body != nil && r.Body != http.NoBody && shouldCaptureBody(cfg, r.Header.Get("Content-Type")) {
+			reqBody = newCaptureReader(r.Body, cfg.MaxBodyCaptureBytes)
+			r.Body = reqBody
 		}
 
 		// Capture request headers
 		reqHeaders := make(map[string]string)
-		if cfg.CaptureHeaders {
+		if plan.captureHeaders {
 			for k, v := range r.Header {
 				reqHeaders[k] = strings.Join(v, ", ")
 			}
@@ -59,35 +54,42 @@ func coreMiddleware(collector *Collector, cfg *Config, next http.Handler) http.H
 
 		// Create trace and attach to context
 		trace := &RequestTrace{
-			ID:               generateID(),
-			Method:           r.Method,
-			Path:             r.URL.Path,
-			QueryParams:      r.URL.RawQuery,
-			RequestHeaders:   reqHeaders,
-			RequestBody:      reqBody,
-			RequestSize:      r.ContentLength,
-			ClientIP:         clientIP(r),
-			UserAgent:        r.UserAgent(),
-			StartTime:        start,
-			GoroutinesBefore: goroutinesBefore,
-			MemAllocBefore:   memBefore.TotalAlloc,
-			DBQueries:        make([]DBQuery, 0),
-			ExternalCalls:    make([]ExternalCall, 0),
-			RedisOps:         make([]RedisOp, 0),
-			MongoOps:         make([]MongoOp, 0),
+			ID:             generateID(),
+			Method:         r.Method,
+			Path:           r.URL.Path,
+			QueryParams:    r.URL.RawQuery,
+			RequestHeaders: reqHeaders,
+			RequestSize:    r.ContentLength,
+			ClientIP:       clientIP(r),
+			UserAgent:      r.UserAgent(),
+			StartTime:      start,
+			DBQueries:      make([]DBQuery, 0),
+			ExternalCalls:  make([]ExternalCall, 0),
+			RedisOps:       make([]RedisOp, 0),
+			MongoOps:       make([]MongoOp, 0),
 		}
+		applyTraceContext(trace, r.Header.Get(traceparentHeader), r.Header.Get(tracestateHeader), plan.sampled())
 
-		ctx := withTrace(r.Context(), trace)
+		rec := newTraceRecorder(collector, trace)
+		ctx := withRecorder(r.Context(), rec)
 		r = r.WithContext(ctx)
 
 		// Wrap response writer
-		rw := newResponseWriter(w, start, cfg.CaptureBody)
+		rw := newResponseWriter(w, start, plan.captureBody)
+		rw.bodyLimit = cfg.MaxBodyCaptureBytes
+		rw.allowBody = func(contentType string) bool { return shouldCaptureBody(cfg, contentType) }
 
 		// Panic recovery
 		defer func() {
-			if rec := recover(); rec != nil {
+			panicValue := recover()
+
+			// Seal the trace so goroutines still running from the handler
+			// can no longer mutate it while it is finalized and recorded.
+			rec.seal()
+
+			if panicValue != nil {
 				trace.Panicked = true
-				trace.PanicValue = rec
+				trace.PanicValue = panicValue
 				buf := make([]byte, 4096)
 				n := runtime.Stack(buf, false)
 				trace.PanicStack = string(buf[:n])
@@ -98,23 +100,30 @@ func coreMiddleware(collector *Collector, cfg *Config, next http.Handler) http.H
 
 			// Finalize trace
 			end := time.Now()
-			var memAfter runtime.MemStats
-			runtime.ReadMemStats(&memAfter)
+			stats.end(trace)
 
+			endOpenSpans(trace, end)
 			trace.EndTime = end
 			trace.Latency = end.Sub(start)
 			trace.TTFB = rw.ttfb
 			trace.HandlerTime = trace.Latency
 			trace.ResponseStatus = rw.statusCode
 			trace.ResponseSize = rw.size
-			trace.GoroutinesAfter = runtime.NumGoroutine()
-			trace.MemAllocAfter = memAfter.TotalAlloc
+			trace.RoutePattern = resolveRoutePattern(rec, r, cfg)
 
-			if cfg.CaptureBody {
-				trace.ResponseBody = rw.body.Bytes()
+			if reqBody != nil {
+				trace.RequestBody = capturedBytes(&reqBody.buf)
+				trace.RequestBodyTruncated = reqBody.truncated
+				if trace.RequestSize < 0 {
+					trace.RequestSize = reqBody.n
+				}
+			}
+			if rw.captureBody {
+				trace.ResponseBody = capturedBytes(&rw.body)
+				trace.ResponseBodyTruncated = rw.bodyTruncated
 			}
 
-			if cfg.CaptureHeaders {
+			if plan.captureHeaders {
 				respHeaders := make(map[string]string)
 				for k, v := range rw.Header() {
 					respHeaders[k] = strings.Join(v, ", ")
@@ -123,7 +132,9 @@ func coreMiddleware(collector *Collector, cfg *Config, next http.Handler) http.H
 			}
 
 			// Record to collector
-			collector.Record(trace)
+			if plan.finish(trace, r.Header.Get) {
+				collector.Record(trace)
+			}
 		}()
 
 		next.ServeHTTP(rw, r)
@@ -6,23 +6,25 @@ import (
 	"github.com/go-chi/chi/v5"
 )
 
-// ChiMiddleware is a Chi-compatible middleware that captures request data.
+// ChiMiddleware is a Chi-compatible middleware that captures request data
+// into the default instance.
 func ChiMiddleware(next http.Handler) http.Handler {
-	if defaultCollector == nil {
-		Init()
-	}
-	wrapped := coreMiddleware(defaultCollector, defaultConfig, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		next.ServeHTTP(w, r)
+	return Default().ChiMiddleware(next)
+}
 
-		// Set route pattern from chi's route context
-		if rctx := chi.RouteContext(r.Context()); rctx != nil {
-			if t := TraceFromContext(r.Context()); t != nil {
-				t.RoutePattern = rctx.RoutePattern()
-				if t.RoutePattern == "" {
-					t.RoutePattern = r.URL.Path
-				}
-			}
-		}
+// ChiMiddleware is a Chi-compatible middleware that captures request data.
+func (i *Instance) ChiMiddleware(next http.Handler) http.Handler {
+	wrapped := coreMiddleware(i.collector, i.config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetRouteResolver(r, chiRoutePattern)
+		next.ServeHTTP(w, r)
 	}))
 	return wrapped
 }
+
+// chiRoutePattern reads the matched pattern from chi's route context.
+func chiRoutePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		return rctx.RoutePattern()
+	}
+	return ""
+}
@@ -6,31 +6,27 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
-// EchoMiddleware returns an Echo middleware that captures request data.
+// EchoMiddleware returns an Echo middleware that captures request data into
+// the default instance.
 func EchoMiddleware() echo.MiddlewareFunc {
-	if defaultCollector == nil {
-		Init()
-	}
+	return Default().EchoMiddleware()
+}
+
+// EchoMiddleware returns an Echo middleware that captures request data.
+func (i *Instance) EchoMiddleware() echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			var echoErr error
 
 			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				c.SetRequest(r)
+				SetRouteResolver(r, func(*http.Request) string { return c.Path() })
 				echoErr = next(c)
 			})
 
-			wrapped := coreMiddleware(defaultCollector, defaultConfig, handler)
+			wrapped := coreMiddleware(i.collector, i.config, handler)
 			wrapped.ServeHTTP(c.Response().Writer, c.Request())
 
-			// Set route pattern
-			if t := TraceFromContext(c.Request().Context()); t != nil {
-				t.RoutePattern = c.Path()
-				if t.RoutePattern == "" {
-					t.RoutePattern = c.Request().URL.Path
-				}
-			}
-
 			return echoErr
 		}
 	}
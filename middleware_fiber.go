@@ -2,75 +2,83 @@ package xrayhq
 
 import (
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-// FiberMiddleware returns a Fiber middleware that captures request data.
+// FiberMiddleware returns a Fiber middleware that captures request data into
+// the default instance.
 func FiberMiddleware() fiber.Handler {
-	if defaultCollector == nil {
-		Init()
-	}
+	return Default().FiberMiddleware()
+}
+
+// FiberMiddleware returns a Fiber middleware that captures request data.
+func (i *Instance) FiberMiddleware() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		cfg := defaultConfig
+		cfg := i.config
 
 		// Sampling
-		if cfg.SamplingRate < 1.0 {
-			b := make([]byte, 1)
-			_, _ = rand.Read(b)
-			if float64(b[0])/255.0 > cfg.SamplingRate {
-				return c.Next()
-			}
+		header := func(name string) string { return c.Get(name) }
+		plan := planSampling(cfg, c.Method(), c.Path(), header)
+		if !plan.trace {
+			return c.Next()
 		}
 
 		start := time.Now()
 
-		var memBefore runtime.MemStats
-		runtime.ReadMemStats(&memBefore)
-		goroutinesBefore := runtime.NumGoroutine()
+		stats := i.collector.beginRequest()
 
 		// Capture request headers
 		reqHeaders := make(map[string]string)
-		if cfg.CaptureHeaders {
+		if plan.captureHeaders {
 			c.Request().Header.VisitAll(func(k, v []byte) {
 				reqHeaders[string(k)] = string(v)
 			})
 		}
 
+		// Fiber has already read the whole body, so it is copied up front.
 		var reqBody []byte
-		if cfg.CaptureBody {
-			reqBody = make([]byte, len(c.Body()))
-			copy(reqBody, c.Body())
+		var reqTruncated bool
+		if plan.captureBody && shouldCaptureBody(cfg, c.Get("Content-Type")) {
+			reqBody, reqTruncated = captureCapped(c.Body(), cfg.MaxBodyCaptureBytes)
 		}
 
 		idBytes := make([]byte, 16)
 		_, _ = rand.Read(idBytes)
 
+		// Strings from the Fiber context point into buffers that are reused
+		// for the next request, so everything kept in the trace is copied.
 		trace := &RequestTrace{
-			ID:               fmt.Sprintf("%x", idBytes),
-			Method:           c.Method(),
-			Path:             c.Path(),
-			RoutePattern:     c.Route().Path,
-			QueryParams:      string(c.Request().URI().QueryString()),
-			RequestHeaders:   reqHeaders,
-			RequestBody:      reqBody,
-			RequestSize:      int64(len(c.Body())),
-			ClientIP:         c.IP(),
-			UserAgent:        c.Get("User-Agent"),
-			StartTime:        start,
-			GoroutinesBefore: goroutinesBefore,
-			MemAllocBefore:   memBefore.TotalAlloc,
-			DBQueries:        make([]DBQuery, 0),
-			ExternalCalls:    make([]ExternalCall, 0),
-			RedisOps:         make([]RedisOp, 0),
-			MongoOps:         make([]MongoOp, 0),
+			ID:                   fmt.Sprintf("%x", idBytes),
+			Method:               strings.Clone(c.Method()),
+			Path:                 strings.Clone(c.Path()),
+			QueryParams:          string(c.Request().URI().QueryString()),
+			RequestHeaders:       reqHeaders,
+			RequestBody:          reqBody,
+			RequestBodyTruncated: reqTruncated,
+			RequestSize:          int64(len(c.Body())),
+			ClientIP:             strings.Clone(c.IP()),
+			UserAgent:            strings.Clone(c.Get("User-Agent")),
+			StartTime:            start,
+			DBQueries:            make([]DBQuery, 0),
+			ExternalCalls:        make([]ExternalCall, 0),
+			RedisOps:             make([]RedisOp, 0),
+			MongoOps:             make([]MongoOp, 0),
 		}
+		applyTraceContext(trace, strings.Clone(c.Get(traceparentHeader)), strings.Clone(c.Get(tracestateHeader)), plan.sampled())
+
+		// Store the recorder in Fiber locals for access by handlers
+		rec := newTraceRecorder(i.collector, trace)
+		c.Locals("xrayhq-recorder", rec)
 
-		// Store trace in Fiber locals for access by handlers
-		c.Locals("xrayhq-trace", trace)
+		// c.Route() is this middleware's own route until a later handler
+		// matches, so the real pattern is only known after c.Next().
+		ownRoute := c.Route()
 
 		var handlerErr error
 		func() {
@@ -87,26 +95,37 @@ func FiberMiddleware() fiber.Handler {
 			handlerErr = c.Next()
 		}()
 
+		rec.seal()
+
 		end := time.Now()
-		var memAfter runtime.MemStats
-		runtime.ReadMemStats(&memAfter)
+		stats.end(trace)
 
+		endOpenSpans(trace, end)
 		trace.EndTime = end
 		trace.Latency = end.Sub(start)
 		trace.TTFB = trace.Latency // Fiber doesn't expose TTFB easily
 		trace.HandlerTime = trace.Latency
 		trace.ResponseStatus = c.Response().StatusCode()
+		if handlerErr != nil {
+			// Fiber's error handler only sets the status after this
+			// middleware returns.
+			trace.ResponseStatus = fiberErrorStatus(handlerErr)
+		}
 		trace.ResponseSize = int64(len(c.Response().Body()))
-		trace.GoroutinesAfter = runtime.NumGoroutine()
-		trace.MemAllocAfter = memAfter.TotalAlloc
 
-		if cfg.CaptureBody {
-			body := c.Response().Body()
-			trace.ResponseBody = make([]byte, len(body))
-			copy(trace.ResponseBody, body)
+		if trace.RoutePattern == "" {
+			if route := c.Route(); route != ownRoute {
+				trace.RoutePattern = route.Path
+			} else {
+				trace.RoutePattern = normalizePath(cfg, trace.Path)
+			}
+		}
+
+		if plan.captureBody && shouldCaptureBody(cfg, string(c.Response().Header.ContentType())) {
+			trace.ResponseBody, trace.ResponseBodyTruncated = captureCapped(c.Response().Body(), cfg.MaxBodyCaptureBytes)
 		}
 
-		if cfg.CaptureHeaders {
+		if plan.captureHeaders {
 			respHeaders := make(map[string]string)
 			c.Response().Header.VisitAll(func(k, v []byte) {
 				respHeaders[string(k)] = string(v)
@@ -114,25 +133,39 @@ func FiberMiddleware() fiber.Handler {
 			trace.ResponseHeaders = respHeaders
 		}
 
-		defaultCollector.Record(trace)
+		if plan.finish(trace, header) {
+			i.collector.Record(trace)
+		}
 
 		return handlerErr
 	}
 }
 
-// FiberTraceFromContext retrieves the trace from Fiber locals.
+// fiberErrorStatus returns the status Fiber's default error handler sends
+// for err.
+func fiberErrorStatus(err error) int {
+	var fe *fiber.Error
+	if errors.As(err, &fe) {
+		return fe.Code
+	}
+	return fiber.StatusInternalServerError
+}
+
+// FiberTraceFromContext returns a copy of the in-flight trace from Fiber
+// locals, like TraceFromContext.
 func FiberTraceFromContext(c *fiber.Ctx) *RequestTrace {
-	if t, ok := c.Locals("xrayhq-trace").(*RequestTrace); ok {
-		return t
+	if rec, ok := c.Locals("xrayhq-recorder").(*traceRecorder); ok {
+		return rec.snapshot()
 	}
 	return nil
 }
 
 // FiberAddDBQuery adds a DB query to the Fiber request trace.
 func FiberAddDBQuery(c *fiber.Ctx, q DBQuery) {
-	if t := FiberTraceFromContext(c); t != nil {
-		t.DBQueries = append(t.DBQueries, q)
-		t.TotalDBTime += q.Duration
+	if rec, ok := c.Locals("xrayhq-recorder").(*traceRecorder); ok {
+		rec.addOp(func(t *RequestTrace) {
+			t.DBQueries = append(t.DBQueries, q)
+			t.TotalDBTime += q.Duration
+		})
 	}
 }
-
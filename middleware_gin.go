@@ -6,29 +6,25 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// GinMiddleware returns a Gin middleware that captures request data.
+// GinMiddleware returns a Gin middleware that captures request data into the
+// default instance.
 func GinMiddleware() gin.HandlerFunc {
-	if defaultCollector == nil {
-		Init()
-	}
+	return Default().GinMiddleware()
+}
+
+// GinMiddleware returns a Gin middleware that captures request data.
+func (i *Instance) GinMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Create a wrapper handler that calls gin's Next
 		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Update gin's request with our context
 			c.Request = r
+			SetRouteResolver(r, func(*http.Request) string { return c.FullPath() })
 			c.Next()
 		})
 
 		// Run through core middleware
-		wrapped := coreMiddleware(defaultCollector, defaultConfig, handler)
+		wrapped := coreMiddleware(i.collector, i.config, handler)
 		wrapped.ServeHTTP(c.Writer, c.Request)
-
-		// Set route pattern after handler runs
-		if t := TraceFromContext(c.Request.Context()); t != nil {
-			t.RoutePattern = c.FullPath()
-			if t.RoutePattern == "" {
-				t.RoutePattern = c.Request.URL.Path
-			}
-		}
 	}
 }
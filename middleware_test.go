@@ -2,10 +2,20 @@ package xrayhq
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-chi/chi/v5"
+	"github.com/gofiber/fiber/v2"
+	"github.com/labstack/echo/v4"
 )
 
 func setupTestCollector() (*Collector, *Config) {
@@ -159,6 +169,48 @@ func TestMiddlewareDBQueryCapture(t *testing.T) {
 	}
 }
 
+func TestTraceFromContextReturnsSnapshot(t *testing.T) {
+	c, cfg := setupTestCollector()
+
+	var ctx context.Context
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = r.Context()
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				AddDBQuery(r.Context(), DBQuery{Query: "SELECT 1", Duration: time.Millisecond})
+			}()
+		}
+		var snap *RequestTrace
+		for i := 0; i < 4; i++ {
+			snap = TraceFromContext(r.Context())
+			if snap == nil {
+				t.Fatal("expected a trace during the request")
+			}
+		}
+		wg.Wait()
+
+		n := len(snap.DBQueries)
+		AddDBQuery(r.Context(), DBQuery{Query: "SELECT 2"})
+		if len(snap.DBQueries) != n {
+			t.Errorf("snapshot changed after the call: %d queries, want %d", len(snap.DBQueries), n)
+		}
+		snap.DBQueries = append(snap.DBQueries, DBQuery{Query: "bogus"})
+		w.Write([]byte("ok"))
+	})
+
+	coreMiddleware(c, cfg, handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/snap", nil))
+
+	if got := len(c.GetRecentRequests(1)[0].DBQueries); got != 5 {
+		t.Errorf("expected 5 recorded DB queries, got %d", got)
+	}
+	if TraceFromContext(ctx) != nil {
+		t.Error("expected no trace after the request finished")
+	}
+}
+
 func TestResponseWriterCapture(t *testing.T) {
 	start := time.Now()
 	rw := newResponseWriter(httptest.NewRecorder(), start, true)
@@ -180,3 +232,447 @@ func TestResponseWriterCapture(t *testing.T) {
 		t.Error("expected positive TTFB")
 	}
 }
+
+func TestMiddlewareServeMuxRoutePattern(t *testing.T) {
+	c, cfg := setupTestCollector()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	wrapped := coreMiddleware(c, cfg, mux)
+
+	wrapped.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/42", nil))
+	wrapped.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/abc", nil))
+
+	routes := c.GetRoutes()
+	if len(routes) != 1 {
+		t.Fatalf("expected 1 route, got %d", len(routes))
+	}
+	if routes[0].Pattern != "/users/{id}" {
+		t.Errorf("expected /users/{id}, got %s", routes[0].Pattern)
+	}
+	if routes[0].TotalRequests != 2 {
+		t.Errorf("expected 2 requests, got %d", routes[0].TotalRequests)
+	}
+}
+
+func TestMiddlewareNormalizesUnmatchedPath(t *testing.T) {
+	c, cfg := setupTestCollector()
+
+	mux := http.NewServeMux()
+	wrapped := coreMiddleware(c, cfg, mux)
+
+	wrapped.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/orders/17/items/3", nil))
+
+	trace := c.GetRecentRequests(1)[0]
+	if trace.ResponseStatus != 404 {
+		t.Errorf("expected 404, got %d", trace.ResponseStatus)
+	}
+	if trace.RoutePattern != "/orders/{id}/items/{id}" {
+		t.Errorf("expected normalized pattern, got %s", trace.RoutePattern)
+	}
+}
+
+func TestDefaultPathNormalizer(t *testing.T) {
+	cases := map[string]string{
+		"/":                    "/",
+		"/api/users":           "/api/users",
+		"/users/123":           "/users/{id}",
+		"/users/123/posts/456": "/users/{id}/posts/{id}",
+		"/files/3f2504e0-4f89-11d3-9a0c-0305e82c3301":        "/files/{uuid}",
+		"/commits/da39a3ee5e6b4b0d3255bfef95601890afd80709/": "/commits/{hash}/",
+		"/v2/items":               "/v2/items",
+		"/blobs/deadbeefcafebabe": "/blobs/deadbeefcafebabe",
+	}
+	for in, want := range cases {
+		if got := DefaultPathNormalizer(in); got != want {
+			t.Errorf("DefaultPathNormalizer(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestMiddlewareRouteResolverRunsBeforeRecord(t *testing.T) {
+	c, cfg := setupTestCollector()
+
+	matched := ""
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetRouteResolver(r, func(*http.Request) string { return matched })
+		// Routers such as Gin only know the matched route once routing runs.
+		matched = "/items/:id"
+		w.Write([]byte("ok"))
+	})
+
+	wrapped := coreMiddleware(c, cfg, handler)
+	wrapped.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/items/9", nil))
+
+	if rm := c.GetRoute("GET", "/items/:id"); rm == nil || rm.TotalRequests != 1 {
+		t.Fatalf("expected route metrics under /items/:id, got %+v", c.GetRoutes())
+	}
+	if trace := c.GetRecentRequests(1)[0]; trace.RoutePattern != "/items/:id" {
+		t.Errorf("expected /items/:id, got %s", trace.RoutePattern)
+	}
+}
+
+// assertAdapterRoute checks that x recorded one request to /users/alice
+// under the router's template rather than the raw path.
+func assertAdapterRoute(t *testing.T, x *Instance, pattern string) {
+	t.Helper()
+	traces := x.Collector().GetRecentRequests(10)
+	if len(traces) != 1 {
+		t.Fatalf("expected 1 trace, got %d", len(traces))
+	}
+	if traces[0].Path != "/users/alice" || traces[0].RoutePattern != pattern {
+		t.Errorf("expected %s recorded under %s, got %s", traces[0].Path, pattern, traces[0].RoutePattern)
+	}
+	if rm := x.Collector().GetRoute("GET", pattern); rm == nil || rm.TotalRequests != 1 {
+		t.Errorf("expected route metrics under %s, got %d routes", pattern, x.Collector().RouteCount())
+	}
+}
+
+func TestGinMiddlewareRoutePattern(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	x, _ := New()
+	r := gin.New()
+	r.Use(x.GinMiddleware())
+	r.GET("/users/:name", func(c *gin.Context) { c.String(200, c.Param("name")) })
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/users/alice", nil))
+	if rec.Code != 200 {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	assertAdapterRoute(t, x, "/users/:name")
+}
+
+func TestEchoMiddlewareRoutePattern(t *testing.T) {
+	x, _ := New()
+	e := echo.New()
+	e.Use(x.EchoMiddleware())
+	e.GET("/users/:name", func(c echo.Context) error { return c.String(200, c.Param("name")) })
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/users/alice", nil))
+	if rec.Code != 200 {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	assertAdapterRoute(t, x, "/users/:name")
+}
+
+func TestChiMiddlewareRoutePattern(t *testing.T) {
+	x, _ := New()
+	r := chi.NewRouter()
+	r.Use(x.ChiMiddleware)
+	r.Get("/users/{name}", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(chi.URLParam(r, "name"))) })
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/users/alice", nil))
+	if rec.Code != 200 {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	assertAdapterRoute(t, x, "/users/{name}")
+}
+
+func TestFiberMiddlewareRoutePattern(t *testing.T) {
+	x, _ := New()
+	app := fiber.New()
+	app.Use(x.FiberMiddleware())
+	app.Get("/users/:name", func(c *fiber.Ctx) error { return c.SendString(c.Params("name")) })
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/users/alice", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	assertAdapterRoute(t, x, "/users/:name")
+}
+
+func TestFiberMiddlewareErrorStatus(t *testing.T) {
+	x, _ := New(WithSamplingMode(SamplingTail), WithSamplingRate(0))
+	app := fiber.New()
+	app.Use(x.FiberMiddleware())
+	app.Get("/teapot", func(c *fiber.Ctx) error { return fiber.NewError(fiber.StatusTeapot, "short and stout") })
+	app.Get("/broken", func(c *fiber.Ctx) error { return errors.New("db down") })
+
+	for path, want := range map[string]int{"/teapot": 418, "/broken": 500} {
+		resp, err := app.Test(httptest.NewRequest("GET", path, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != want {
+			t.Fatalf("expected Fiber to send %d, got %d", want, resp.StatusCode)
+		}
+		if rm := x.Collector().GetRoute("GET", path); rm == nil || rm.StatusCodes[want] != 1 {
+			t.Errorf("expected %s recorded as %d", path, want)
+		}
+	}
+
+	// Tail sampling keeps the 5xx and drops the rest.
+	traces := x.Collector().GetRecentRequests(10)
+	if len(traces) != 1 || traces[0].Path != "/broken" || traces[0].ResponseStatus != 500 {
+		t.Errorf("expected only the 500 kept, got %d traces", len(traces))
+	}
+}
+
+func TestMiddlewareConcurrentOperations(t *testing.T) {
+	c, cfg := setupTestCollector()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				AddDBQuery(r.Context(), DBQuery{Query: "SELECT 1", Duration: time.Millisecond})
+				AddExternalCall(r.Context(), ExternalCall{URL: "http://svc", Duration: time.Millisecond})
+				AddRedisOp(r.Context(), RedisOp{Command: "GET", Duration: time.Millisecond})
+				AddMongoOp(r.Context(), MongoOp{Operation: "find", Duration: time.Millisecond})
+			}()
+		}
+		wg.Wait()
+	})
+
+	wrapped := coreMiddleware(c, cfg, handler)
+	wrapped.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fanout", nil))
+
+	trace := c.GetRecentRequests(1)[0]
+	if len(trace.DBQueries) != 50 || len(trace.ExternalCalls) != 50 ||
+		len(trace.RedisOps) != 50 || len(trace.MongoOps) != 50 {
+		t.Errorf("expected 50 of each operation, got db=%d ext=%d redis=%d mongo=%d",
+			len(trace.DBQueries), len(trace.ExternalCalls), len(trace.RedisOps), len(trace.MongoOps))
+	}
+	if trace.TotalDBTime != 50*time.Millisecond {
+		t.Errorf("expected 50ms total DB time, got %v", trace.TotalDBTime)
+	}
+}
+
+func TestMiddlewareLateOperations(t *testing.T) {
+	c, cfg := setupTestCollector()
+
+	var reqCtx context.Context
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqCtx = r.Context()
+		AddDBQuery(r.Context(), DBQuery{Query: "SELECT 1"})
+	})
+
+	wrapped := coreMiddleware(c, cfg, handler)
+	wrapped.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/late", nil))
+
+	// A goroutine outliving the handler reports after the trace is recorded.
+	AddDBQuery(reqCtx, DBQuery{Query: "SELECT 2"})
+
+	trace := c.GetRecentRequests(1)[0]
+	if len(trace.DBQueries) != 1 {
+		t.Errorf("expected late query not to be attached, got %d queries", len(trace.DBQueries))
+	}
+	if c.LateOps() != 1 {
+		t.Errorf("expected 1 late operation, got %d", c.LateOps())
+	}
+}
+
+func TestMiddlewareBodyCaptureIsBounded(t *testing.T) {
+	c, cfg := setupTestCollector()
+	cfg.MaxBodyCaptureBytes = 8
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := new(bytes.Buffer)
+		buf.ReadFrom(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(buf.Bytes())
+		w.Write(buf.Bytes())
+	})
+
+	body := strings.Repeat("x", 20)
+	req := httptest.NewRequest("POST", "/upload", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/plain")
+	rec := httptest.NewRecorder()
+	coreMiddleware(c, cfg, handler).ServeHTTP(rec, req)
+
+	if rec.Body.Len() != 40 {
+		t.Fatalf("expected handler to see the full body, got %d bytes", rec.Body.Len())
+	}
+	trace := c.GetRecentRequests(1)[0]
+	if string(trace.RequestBody) != "xxxxxxxx" || !trace.RequestBodyTruncated {
+		t.Errorf("expected 8 captured request bytes and truncation, got %q %v", trace.RequestBody, trace.RequestBodyTruncated)
+	}
+	if len(trace.ResponseBody) != 8 || !trace.ResponseBodyTruncated {
+		t.Errorf("expected 8 captured response bytes and truncation, got %d %v", len(trace.ResponseBody), trace.ResponseBodyTruncated)
+	}
+	if trace.ResponseSize != 40 {
+		t.Errorf("expected full response size, got %d", trace.ResponseSize)
+	}
+	if !trace.Truncated() {
+		t.Error("expected Truncated() to report the cut")
+	}
+}
+
+func TestMiddlewareBodyCaptureDoesNotReadAhead(t *testing.T) {
+	c, cfg := setupTestCollector()
+
+	pr, pw := io.Pipe()
+	firstRead := make(chan string)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, 5)
+		n, _ := io.ReadFull(r.Body, buf)
+		firstRead <- string(buf[:n])
+		rest, _ := io.ReadAll(r.Body)
+		w.Write(rest)
+	})
+
+	req := httptest.NewRequest("POST", "/stream", pr)
+	req.ContentLength = -1
+	done := make(chan struct{})
+	go func() {
+		coreMiddleware(c, cfg, handler).ServeHTTP(httptest.NewRecorder(), req)
+		close(done)
+	}()
+
+	// The handler must get the first chunk while the client is still
+	// sending, which would deadlock if the middleware buffered the body.
+	pw.Write([]byte("hello"))
+	if got := <-firstRead; got != "hello" {
+		t.Fatalf("expected first chunk, got %q", got)
+	}
+	pw.Write([]byte(" world"))
+	pw.Close()
+	<-done
+
+	trace := c.GetRecentRequests(1)[0]
+	if string(trace.RequestBody) != "hello world" {
+		t.Errorf("expected streamed body to be captured, got %q", trace.RequestBody)
+	}
+	if trace.RequestSize != 11 {
+		t.Errorf("expected size from bytes read, got %d", trace.RequestSize)
+	}
+}
+
+func TestMiddlewareBodyCaptureSkipsContentTypes(t *testing.T) {
+	c, cfg := setupTestCollector()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG"))
+	})
+
+	req := httptest.NewRequest("POST", "/avatar", strings.NewReader("--b\r\n"))
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=b")
+	coreMiddleware(c, cfg, handler).ServeHTTP(httptest.NewRecorder(), req)
+
+	trace := c.GetRecentRequests(1)[0]
+	if trace.RequestBody != nil || trace.ResponseBody != nil {
+		t.Errorf("expected multipart and image bodies to be skipped, got %q and %q", trace.RequestBody, trace.ResponseBody)
+	}
+	if trace.ResponseSize != 4 {
+		t.Errorf("expected response size to be recorded, got %d", trace.ResponseSize)
+	}
+}
+
+func TestShouldCaptureBody(t *testing.T) {
+	cfg := DefaultConfig()
+	cases := map[string]bool{
+		"":                                  true,
+		"application/json":                  true,
+		"text/html; charset=utf-8":          true,
+		"multipart/form-data; boundary=abc": false,
+		"image/jpeg":                        false,
+		"Application/Octet-Stream":          false,
+	}
+	for ct, want := range cases {
+		if got := shouldCaptureBody(cfg, ct); got != want {
+			t.Errorf("shouldCaptureBody(%q) = %v, want %v", ct, got, want)
+		}
+	}
+
+	cfg.CaptureContentTypes = []string{"application/json", "text/*"}
+	if !shouldCaptureBody(cfg, "text/plain") || shouldCaptureBody(cfg, "application/xml") {
+		t.Error("expected allow list to restrict capture")
+	}
+}
+
+func TestMiddlewareTailSamplingTracesEveryRequest(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.SamplingMode = SamplingTail
+	cfg.SamplingRate = 0
+	c := NewCollector(cfg)
+
+	handler := coreMiddleware(c, cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	for _, path := range []string{"/ok", "/ok", "/fail"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	if got := c.GetRoute("GET", "/ok"); got == nil || got.TotalRequests != 2 {
+		t.Errorf("expected /ok to be counted twice, got %+v", got)
+	}
+	if c.RequestCount() != 1 || c.GetRecentRequests(1)[0].Path != "/fail" {
+		t.Error("expected only the failing request to be stored")
+	}
+}
+
+func TestMiddlewareRuntimeSampling(t *testing.T) {
+	c, cfg := setupTestCollector()
+	cfg.RuntimeSampling = 2
+
+	handler := coreMiddleware(c, cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := 0; i < 4; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+
+	sampled := 0
+	for _, tr := range c.GetAllRequests() {
+		if tr.RuntimeSampled {
+			sampled++
+			if tr.GoroutinesBefore == 0 || tr.MemAllocAfter < tr.MemAllocBefore {
+				t.Errorf("expected runtime readings on sampled trace, got %+v", tr)
+			}
+		}
+		if tr.ConcurrentRequests != 1 {
+			t.Errorf("expected one request in flight, got %d", tr.ConcurrentRequests)
+		}
+	}
+	if sampled != 2 {
+		t.Errorf("expected every 2nd request sampled, got %d of 4", sampled)
+	}
+
+	cfg.RuntimeSampling = 0
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if c.GetRecentRequests(1)[0].RuntimeSampled {
+		t.Error("expected no runtime readings when sampling is off")
+	}
+}
+
+func TestMiddlewareConcurrentRequests(t *testing.T) {
+	c, cfg := setupTestCollector()
+
+	release := make(chan struct{})
+	var started sync.WaitGroup
+	started.Add(3)
+	handler := coreMiddleware(c, cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started.Done()
+		<-release
+	}))
+
+	var done sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		}()
+	}
+	started.Wait()
+	close(release)
+	done.Wait()
+
+	for _, tr := range c.GetAllRequests() {
+		if tr.ConcurrentRequests != 3 {
+			t.Errorf("expected 3 concurrent requests, got %d", tr.ConcurrentRequests)
+		}
+	}
+}
@@ -0,0 +1,549 @@
+package xrayhq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OTLPConfig configures export of captured traces to an OpenTelemetry
+// collector over OTLP/HTTP with JSON encoding.
+type OTLPConfig struct {
+	// Endpoint is the full traces URL, e.g. http://localhost:4318/v1/traces.
+	Endpoint string
+	// Headers are added to every export request, e.g. for authentication.
+	Headers map[string]string
+	// ServiceName is reported as the service.name resource attribute.
+	ServiceName string
+
+	BatchSize     int           // traces per export request
+	QueueSize     int           // traces buffered before new ones are dropped
+	FlushInterval time.Duration // maximum time a trace waits in a partial batch
+	Timeout       time.Duration // per export request
+	// MaxRetries is how many times a failed batch is retried. Zero uses the
+	// default of 5; a negative value disables retries.
+	MaxRetries int
+	Client     *http.Client
+}
+
+func (c OTLPConfig) withDefaults() OTLPConfig {
+	if c.ServiceName == "" {
+		c.ServiceName = "xrayhq"
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 100
+	}
+	if c.QueueSize <= 0 {
+		c.QueueSize = 2048
+	}
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = 5 * time.Second
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * time.Second
+	}
+	if c.MaxRetries < 0 {
+		c.MaxRetries = 0
+	} else if c.MaxRetries == 0 {
+		c.MaxRetries = 5
+	}
+	if c.Client == nil {
+		c.Client = &http.Client{}
+	}
+	return c
+}
+
+const (
+	otlpRetryInitialBackoff = 100 * time.Millisecond
+	otlpRetryMaxBackoff     = 5 * time.Second
+	// otlpRetryMaxAfter caps the wait a collector can ask for with
+	// Retry-After, so a bad value cannot stall the exporter for hours.
+	otlpRetryMaxAfter = time.Minute
+)
+
+// otlpExporter batches recorded traces on a background goroutine and posts
+// them to the collector. Enqueueing never blocks the request path: when the
+// queue is full the trace is dropped and counted.
+type otlpExporter struct {
+	cfg OTLPConfig
+
+	queue    chan *RequestTrace
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+
+	// ctx aborts in-flight exports and retry backoff when Shutdown's
+	// deadline passes before the final flush completes.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// stopMu makes the stopped check and the send in enqueue atomic with
+	// respect to shutdown, so nothing is queued after the final drain
+	// starts.
+	stopMu  sync.RWMutex
+	stopped bool
+
+	dropped atomic.Int64 // traces not queued: queue full or shut down
+	failed  atomic.Int64 // traces in batches that failed after all retries
+}
+
+func newOTLPExporter(cfg OTLPConfig) *otlpExporter {
+	cfg = cfg.withDefaults()
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &otlpExporter{
+		cfg:    cfg,
+		queue:  make(chan *RequestTrace, cfg.QueueSize),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	go e.run()
+	return e
+}
+
+func (e *otlpExporter) enqueue(t *RequestTrace) {
+	e.stopMu.RLock()
+	defer e.stopMu.RUnlock()
+	if e.stopped {
+		e.dropped.Add(1)
+		return
+	}
+	select {
+	case e.queue <- t:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+func (e *otlpExporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*RequestTrace, 0, e.cfg.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.export(batch); err != nil {
+			e.failed.Add(int64(len(batch)))
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case t := <-e.queue:
+			batch = append(batch, t)
+			if len(batch) >= e.cfg.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-e.stop:
+			for {
+				select {
+				case t := <-e.queue:
+					batch = append(batch, t)
+					if len(batch) >= e.cfg.BatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// shutdown stops accepting traces and flushes everything queued. If ctx
+// expires first, pending exports are abandoned and ctx's error is returned.
+func (e *otlpExporter) shutdown(ctx context.Context) error {
+	e.stopOnce.Do(func() {
+		e.stopMu.Lock()
+		e.stopped = true
+		e.stopMu.Unlock()
+		close(e.stop)
+	})
+	select {
+	case <-e.done:
+		e.cancel()
+		return nil
+	case <-ctx.Done():
+		e.cancel()
+		<-e.done
+		return ctx.Err()
+	}
+}
+
+// export posts one batch, retrying with exponential backoff on network
+// errors and on the status codes OTLP/HTTP marks as retryable. A Retry-After
+// sent with 429 or 503 replaces the backoff for that attempt.
+func (e *otlpExporter) export(batch []*RequestTrace) error {
+	body, err := json.Marshal(buildOTLPRequest(batch, e.cfg.ServiceName))
+	if err != nil {
+		return err
+	}
+
+	backoff := otlpRetryInitialBackoff
+	for attempt := 0; ; attempt++ {
+		retryable, retryAfter, err := e.post(body)
+		if err == nil {
+			return nil
+		}
+		if !retryable || attempt >= e.cfg.MaxRetries {
+			return err
+		}
+		wait := backoff
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		select {
+		case <-time.After(wait):
+		case <-e.ctx.Done():
+			return e.ctx.Err()
+		}
+		backoff *= 2
+		if backoff > otlpRetryMaxBackoff {
+			backoff = otlpRetryMaxBackoff
+		}
+	}
+}
+
+// post sends one request. For a retryable failure it also returns the wait
+// the collector asked for with Retry-After, or zero.
+func (e *otlpExporter) post(body []byte) (retryable bool, retryAfter time.Duration, err error) {
+	ctx, cancel := context.WithTimeout(e.ctx, e.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.cfg.Client.Do(req)
+	if err != nil {
+		return !errors.Is(err, context.Canceled), 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, 0, nil
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		retryable = true
+		retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		retryable = true
+	}
+	return retryable, retryAfter, fmt.Errorf("xrayhq: OTLP export failed: %s", resp.Status)
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP
+// date, capped at otlpRetryMaxAfter. It returns zero if the header is
+// missing or invalid.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	var d time.Duration
+	if secs, err := strconv.Atoi(v); err == nil {
+		d = time.Duration(secs) * time.Second
+	} else if at, err := http.ParseTime(v); err == nil {
+		d = at.Sub(now)
+	}
+	return min(max(d, 0), otlpRetryMaxAfter)
+}
+
+// OTLP/JSON wire types. Only the fields xrayhq produces are modelled.
+
+type otlpExportRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	TraceState        string         `json:"traceState,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+const (
+	otlpSpanKindInternal = 1
+	otlpSpanKindServer   = 2
+	otlpSpanKindClient   = 3
+
+	otlpStatusError = 2
+)
+
+func otlpString(k, v string) otlpKeyValue {
+	return otlpKeyValue{Key: k, Value: otlpAnyValue{StringValue: &v}}
+}
+
+func otlpInt(k string, v int64) otlpKeyValue {
+	s := strconv.FormatInt(v, 10)
+	return otlpKeyValue{Key: k, Value: otlpAnyValue{IntValue: &s}}
+}
+
+func otlpAttr(k string, v interface{}) otlpKeyValue {
+	switch v := v.(type) {
+	case string:
+		return otlpString(k, v)
+	case bool:
+		return otlpKeyValue{Key: k, Value: otlpAnyValue{BoolValue: &v}}
+	case int:
+		return otlpInt(k, int64(v))
+	case int64:
+		return otlpInt(k, v)
+	case int32:
+		return otlpInt(k, int64(v))
+	case float64:
+		return otlpKeyValue{Key: k, Value: otlpAnyValue{DoubleValue: &v}}
+	case float32:
+		f := float64(v)
+		return otlpKeyValue{Key: k, Value: otlpAnyValue{DoubleValue: &f}}
+	default:
+		return otlpString(k, fmt.Sprint(v))
+	}
+}
+
+func otlpTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func buildOTLPRequest(batch []*RequestTrace, serviceName string) otlpExportRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, t := range batch {
+		spans = append(spans, traceToOTLPSpans(t)...)
+	}
+	return otlpExportRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{
+			otlpString("service.name", serviceName),
+			otlpString("telemetry.sdk.name", "xrayhq"),
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/Bhavyyadav25/xrayhq"},
+			Spans: spans,
+		}},
+	}}}
+}
+
+// traceToOTLPSpans converts a request into a server span with a child span
+// for every custom span and captured operation, using OpenTelemetry
+// semantic-convention attribute names.
+func traceToOTLPSpans(t *RequestTrace) []otlpSpan {
+	traceID := t.TraceID
+	if traceID == "" {
+		traceID = t.ID
+	}
+	rootID := t.SpanID
+	if rootID == "" {
+		rootID = generateSpanID()
+	}
+	parentOf := func(spanID string) string {
+		if spanID != "" {
+			return spanID
+		}
+		return rootID
+	}
+
+	route := t.RoutePattern
+	if route == "" {
+		route = t.Path
+	}
+	root := otlpSpan{
+		TraceID:           traceID,
+		SpanID:            rootID,
+		ParentSpanID:      t.ParentSpanID,
+		TraceState:        t.TraceState,
+		Name:              t.Method + " " + route,
+		Kind:              otlpSpanKindServer,
+		StartTimeUnixNano: otlpTime(t.StartTime),
+		EndTimeUnixNano:   otlpTime(t.StartTime.Add(t.Latency)),
+		Attributes: []otlpKeyValue{
+			otlpString("http.request.method", t.Method),
+			otlpString("url.path", t.Path),
+			otlpString("http.route", t.RoutePattern),
+			otlpInt("http.response.status_code", int64(t.ResponseStatus)),
+			otlpInt("http.request.body.size", t.RequestSize),
+			otlpInt("http.response.body.size", t.ResponseSize),
+			otlpString("xrayhq.request_id", t.ID),
+		},
+	}
+	if t.QueryParams != "" {
+		root.Attributes = append(root.Attributes, otlpString("url.query", t.QueryParams))
+	}
+	if t.ClientIP != "" {
+		root.Attributes = append(root.Attributes, otlpString("client.address", t.ClientIP))
+	}
+	if t.UserAgent != "" {
+		root.Attributes = append(root.Attributes, otlpString("user_agent.original", t.UserAgent))
+	}
+	if t.ResponseStatus >= 500 {
+		root.Status = otlpStatus{Code: otlpStatusError}
+		root.Attributes = append(root.Attributes, otlpString("error.type", strconv.Itoa(t.ResponseStatus)))
+	}
+	if t.Panicked {
+		msg := fmt.Sprint(t.PanicValue)
+		root.Status = otlpStatus{Code: otlpStatusError, Message: msg}
+		root.Events = append(root.Events, otlpEvent{
+			TimeUnixNano: otlpTime(t.StartTime.Add(t.Latency)),
+			Name:         "exception",
+			Attributes: []otlpKeyValue{
+				otlpString("exception.message", msg),
+				otlpString("exception.stacktrace", t.PanicStack),
+			},
+		})
+	}
+
+	spans := make([]otlpSpan, 0, 1+len(t.Spans)+len(t.DBQueries)+len(t.ExternalCalls)+len(t.RedisOps)+len(t.MongoOps))
+	spans = append(spans, root)
+
+	child := func(spanID, parentID, name string, kind int, start time.Time, d time.Duration, errMsg string, attrs []otlpKeyValue) {
+		s := otlpSpan{
+			TraceID:           traceID,
+			SpanID:            spanID,
+			ParentSpanID:      parentOf(parentID),
+			Name:              name,
+			Kind:              kind,
+			StartTimeUnixNano: otlpTime(start),
+			EndTimeUnixNano:   otlpTime(start.Add(d)),
+			Attributes:        attrs,
+		}
+		if errMsg != "" {
+			s.Status = otlpStatus{Code: otlpStatusError, Message: errMsg}
+		}
+		spans = append(spans, s)
+	}
+
+	for _, s := range t.Spans {
+		attrs := make([]otlpKeyValue, 0, len(s.Attrs))
+		for k, v := range s.Attrs {
+			attrs = append(attrs, otlpAttr(k, v))
+		}
+		child(s.ID, s.ParentID, s.Name, otlpSpanKindInternal, s.StartTime, s.Duration, s.Error, attrs)
+	}
+	for _, q := range t.DBQueries {
+		op := strings.ToUpper(firstWord(q.Query))
+		child(generateSpanID(), q.ParentSpanID, queryPattern(q.Query), otlpSpanKindClient, q.Timestamp, q.Duration, q.Error, []otlpKeyValue{
+			otlpString("db.query.text", q.Query),
+			otlpString("db.operation.name", op),
+			otlpInt("xrayhq.db.rows_affected", q.RowsAffected),
+		})
+	}
+	for _, c := range t.ExternalCalls {
+		spanID := c.SpanID
+		if spanID == "" {
+			spanID = generateSpanID()
+		}
+		attrs := []otlpKeyValue{
+			otlpString("http.request.method", c.Method),
+			otlpString("url.full", c.URL),
+		}
+		if c.StatusCode != 0 {
+			attrs = append(attrs, otlpInt("http.response.status_code", int64(c.StatusCode)))
+		}
+		errMsg := c.Error
+		if errMsg == "" && c.StatusCode >= 500 {
+			errMsg = strconv.Itoa(c.StatusCode)
+		}
+		child(spanID, c.ParentSpanID, c.Method, otlpSpanKindClient, c.Timestamp, c.Duration, errMsg, attrs)
+	}
+	for _, op := range t.RedisOps {
+		attrs := []otlpKeyValue{
+			otlpString("db.system.name", "redis"),
+			otlpString("db.operation.name", op.Command),
+		}
+		if op.Key != "" {
+			attrs = append(attrs, otlpString("db.query.text", op.Command+" "+op.Key))
+		}
+		child(generateSpanID(), op.ParentSpanID, op.Command, otlpSpanKindClient, op.Timestamp, op.Duration, op.Error, attrs)
+	}
+	for _, op := range t.MongoOps {
+		attrs := []otlpKeyValue{
+			otlpString("db.system.name", "mongodb"),
+			otlpString("db.operation.name", op.Operation),
+			otlpString("db.collection.name", op.Collection),
+		}
+		if op.Filter != "" {
+			attrs = append(attrs, otlpString("db.query.text", op.Filter))
+		}
+		name := strings.TrimSpace(op.Operation + " " + op.Collection)
+		child(generateSpanID(), op.ParentSpanID, name, otlpSpanKindClient, op.Timestamp, op.Duration, op.Error, attrs)
+	}
+	return spans
+}
+
+func firstWord(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexAny(s, " \t\n"); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
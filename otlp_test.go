@@ -0,0 +1,310 @@
+package xrayhq
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type otlpTestCollector struct {
+	mu         sync.Mutex
+	requests   []otlpExportRequest
+	attempts   int
+	failures   int    // respond 503 to this many requests first
+	retryAfter string // Retry-After sent with those responses
+	headers    http.Header
+}
+
+func (c *otlpTestCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.attempts++
+	c.headers = r.Header.Clone()
+	if c.failures > 0 {
+		c.failures--
+		if c.retryAfter != "" {
+			w.Header().Set("Retry-After", c.retryAfter)
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var req otlpExportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.requests = append(c.requests, req)
+}
+
+func (c *otlpTestCollector) spans() []otlpSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out []otlpSpan
+	for _, r := range c.requests {
+		for _, rs := range r.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				out = append(out, ss.Spans...)
+			}
+		}
+	}
+	return out
+}
+
+func attrValue(s otlpSpan, key string) string {
+	for _, kv := range s.Attributes {
+		if kv.Key != key {
+			continue
+		}
+		switch {
+		case kv.Value.StringValue != nil:
+			return *kv.Value.StringValue
+		case kv.Value.IntValue != nil:
+			return *kv.Value.IntValue
+		}
+	}
+	return ""
+}
+
+func TestOTLPExporterConvertsTrace(t *testing.T) {
+	col := &otlpTestCollector{}
+	srv := httptest.NewServer(col)
+	defer srv.Close()
+
+	cfg := DefaultConfig()
+	cfg.OTLP = &OTLPConfig{
+		Endpoint:    srv.URL + "/v1/traces",
+		ServiceName: "checkout",
+		Headers:     map[string]string{"Authorization": "Bearer t"},
+	}
+	c := NewCollector(cfg)
+
+	start := time.Now()
+	c.Record(&RequestTrace{
+		ID:             "req-1",
+		TraceID:        "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:         "00f067aa0ba902b7",
+		Method:         "POST",
+		Path:           "/orders/7",
+		RoutePattern:   "/orders/{id}",
+		ResponseStatus: 502,
+		StartTime:      start,
+		Latency:        50 * time.Millisecond,
+		Spans:          []*Span{{ID: "1111111111111111", Name: "price", StartTime: start, Duration: 10 * time.Millisecond}},
+		DBQueries:      []DBQuery{{Query: "SELECT * FROM orders WHERE id = ?", Timestamp: start, Duration: time.Millisecond, RowsAffected: 3, ParentSpanID: "1111111111111111"}},
+		ExternalCalls:  []ExternalCall{{URL: "http://pricing/quote", Method: "GET", StatusCode: 200, SpanID: "2222222222222222", Timestamp: start}},
+		RedisOps:       []RedisOp{{Command: "SET", Key: "cart:7", Timestamp: start}},
+		MongoOps:       []MongoOp{{Operation: "find", Collection: "carts", Timestamp: start}},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	spans := col.spans()
+	if len(spans) != 6 {
+		t.Fatalf("expected 6 spans, got %d", len(spans))
+	}
+	if col.headers.Get("Authorization") != "Bearer t" || col.headers.Get("Content-Type") != "application/json" {
+		t.Errorf("expected configured headers, got %v", col.headers)
+	}
+	if name := *col.requests[0].ResourceSpans[0].Resource.Attributes[0].Value.StringValue; name != "checkout" {
+		t.Errorf("expected service.name checkout, got %s", name)
+	}
+
+	root := spans[0]
+	if root.Kind != otlpSpanKindServer || root.Name != "POST /orders/{id}" || root.SpanID != "00f067aa0ba902b7" {
+		t.Errorf("unexpected root span %+v", root)
+	}
+	if attrValue(root, "http.route") != "/orders/{id}" || attrValue(root, "http.response.status_code") != "502" {
+		t.Errorf("expected semantic convention attributes on root span")
+	}
+	if root.Status.Code != otlpStatusError {
+		t.Errorf("expected error status for 502")
+	}
+
+	byName := make(map[string]otlpSpan)
+	for _, s := range spans[1:] {
+		if s.TraceID != root.TraceID {
+			t.Errorf("span %s has trace ID %s", s.Name, s.TraceID)
+		}
+		byName[s.Name] = s
+	}
+	if byName["price"].ParentSpanID != root.SpanID {
+		t.Errorf("expected custom span under root")
+	}
+	if db := byName["SELECT orders"]; db.ParentSpanID != "1111111111111111" || attrValue(db, "db.query.text") == "" {
+		t.Errorf("expected DB span under custom span, got %+v", db)
+	}
+	if attrValue(byName["SELECT orders"], "xrayhq.db.rows_affected") != "3" {
+		t.Errorf("expected rows affected on DB span")
+	}
+	if ext := byName["GET"]; ext.SpanID != "2222222222222222" || attrValue(ext, "url.full") != "http://pricing/quote" {
+		t.Errorf("expected external span to reuse propagated span ID, got %+v", ext)
+	}
+	if attrValue(byName["GET"], "http.request.method") != "GET" {
+		t.Errorf("expected http.request.method on client span")
+	}
+	if attrValue(byName["find carts"], "db.system.name") != "mongodb" {
+		t.Errorf("expected mongo span")
+	}
+}
+
+func TestOTLPExporterRetriesAndBatches(t *testing.T) {
+	col := &otlpTestCollector{failures: 2}
+	srv := httptest.NewServer(col)
+	defer srv.Close()
+
+	e := newOTLPExporter(OTLPConfig{
+		Endpoint:      srv.URL,
+		BatchSize:     10,
+		FlushInterval: time.Hour,
+	})
+	for i := 0; i < 25; i++ {
+		e.enqueue(&RequestTrace{ID: generateID(), Method: "GET", Path: "/", StartTime: time.Now()})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	if got := len(col.spans()); got != 25 {
+		t.Errorf("expected 25 exported spans, got %d", got)
+	}
+	if len(col.requests) != 3 {
+		t.Errorf("expected 3 batches, got %d", len(col.requests))
+	}
+	if col.attempts != 5 {
+		t.Errorf("expected 2 retried attempts plus 3 batches, got %d attempts", col.attempts)
+	}
+	if e.failed.Load() != 0 {
+		t.Errorf("expected no failed traces, got %d", e.failed.Load())
+	}
+
+	e.enqueue(&RequestTrace{ID: "after-shutdown"})
+	if e.dropped.Load() != 1 {
+		t.Errorf("expected trace enqueued after shutdown to be dropped")
+	}
+}
+
+func TestOTLPExporterHonorsRetryAfter(t *testing.T) {
+	col := &otlpTestCollector{failures: 1, retryAfter: "1"}
+	srv := httptest.NewServer(col)
+	defer srv.Close()
+
+	e := newOTLPExporter(OTLPConfig{Endpoint: srv.URL, FlushInterval: time.Hour})
+	e.enqueue(&RequestTrace{ID: generateID(), Method: "GET", Path: "/", StartTime: time.Now()})
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("expected the retry to wait for Retry-After, took %v", elapsed)
+	}
+	if got := len(col.spans()); got != 1 {
+		t.Errorf("expected 1 exported span, got %d", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"", 0},
+		{"3", 3 * time.Second},
+		{"-1", 0},
+		{"soon", 0},
+		{"3600", otlpRetryMaxAfter},
+		{now.Add(10 * time.Second).Format(http.TimeFormat), 10 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.in, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestOTLPExporterDropsWhenQueueFull(t *testing.T) {
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	e := newOTLPExporter(OTLPConfig{Endpoint: srv.URL, BatchSize: 1, QueueSize: 2, MaxRetries: -1})
+	for i := 0; i < 10; i++ {
+		e.enqueue(&RequestTrace{ID: generateID()})
+	}
+	if e.dropped.Load() == 0 {
+		t.Error("expected traces to be dropped once the queue is full")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := e.shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected shutdown to give up at the deadline, got %v", err)
+	}
+}
+
+func TestCollectorReportsExportLosses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	cfg := DefaultConfig()
+	cfg.OTLP = &OTLPConfig{Endpoint: srv.URL, BatchSize: 2, FlushInterval: time.Hour}
+	c := NewCollector(cfg)
+	for i := 0; i < 4; i++ {
+		c.Record(&RequestTrace{ID: generateID(), Method: "GET", RoutePattern: "/", StartTime: time.Now()})
+	}
+
+	// Record from several goroutines while shutting down: every trace must
+	// end up either exported, failed or dropped.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				c.Record(&RequestTrace{ID: generateID(), Method: "GET", RoutePattern: "/", StartTime: time.Now()})
+			}
+		}()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.exporter.shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	wg.Wait()
+
+	if got := c.ExportFailed() + c.ExportDropped(); got != 204 {
+		t.Errorf("expected all 204 traces failed or dropped, got %d failed and %d dropped", c.ExportFailed(), c.ExportDropped())
+	}
+	if c.ExportFailed() < 4 {
+		t.Errorf("expected the first batches to fail, got %d failed", c.ExportFailed())
+	}
+
+	rec := httptest.NewRecorder()
+	c.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, name := range []string{"xrayhq_otlp_dropped_total", "xrayhq_otlp_failed_total"} {
+		if !strings.Contains(rec.Body.String(), name+" ") {
+			t.Errorf("expected %s in metrics", name)
+		}
+	}
+}
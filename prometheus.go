@@ -0,0 +1,228 @@
+package xrayhq
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// promLatencyBuckets are the upper bounds, in seconds, of the route latency
+// histograms: the Prometheus client's default buckets.
+var promLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// MetricsHandler returns an http.Handler that serves the collector's metrics
+// in the Prometheus text exposition format. The dashboard serves it at
+// /metrics; it can also be mounted on any mux.
+func (c *Collector) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.WriteMetrics(w)
+	})
+}
+
+// WriteMetrics writes the collector's metrics to w in the Prometheus text
+// exposition format:
+//
+//   - request counts by method, route and status class, and per-route
+//     latency histograms, estimated from the route's LatencySketch: a
+//     bucket counts every request at or below its bound, and may also count
+//     some within the sketch's 1% relative accuracy above it;
+//   - DB, Redis, MongoDB and external call counts and durations per route;
+//   - alerts fired by type, and the alert groups active by type;
+//   - the collector's own buffer usage, route table and dropped traces.
+//
+// Route metrics only cover traced requests; routes past MaxRoutes are
+// counted under OverflowRoute.
+func (c *Collector) WriteMetrics(w io.Writer) error {
+	pw := &promWriter{w: bufio.NewWriter(w)}
+
+	routes := c.GetRoutes()
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Pattern != routes[j].Pattern {
+			return routes[i].Pattern < routes[j].Pattern
+		}
+		return routes[i].Method < routes[j].Method
+	})
+
+	pw.family("xrayhq_requests_total", "counter", "Traced requests by method, route and status class.")
+	for _, rm := range routes {
+		classes := make(map[string]int64)
+		for code, n := range rm.StatusCodes {
+			classes[statusClass(code)] += n
+		}
+		for _, class := range sortedKeys(classes) {
+			pw.sample("xrayhq_requests_total", classes[class], "method", rm.Method, "route", rm.Pattern, "status", class)
+		}
+	}
+
+	pw.family("xrayhq_request_duration_seconds", "histogram", "Request latency by method and route. Buckets are estimated to within 1% of their bounds.")
+	for _, rm := range routes {
+		for _, le := range promLatencyBuckets {
+			n := rm.Latency.CountAtOrBelow(time.Duration(le * float64(time.Second)))
+			pw.sample("xrayhq_request_duration_seconds_bucket", n, "method", rm.Method, "route", rm.Pattern, "le", formatFloat(le))
+		}
+		pw.sample("xrayhq_request_duration_seconds_bucket", rm.Latency.Count(), "method", rm.Method, "route", rm.Pattern, "le", "+Inf")
+		pw.sample("xrayhq_request_duration_seconds_sum", rm.TotalLatency.Seconds(), "method", rm.Method, "route", rm.Pattern)
+		pw.sample("xrayhq_request_duration_seconds_count", rm.Latency.Count(), "method", rm.Method, "route", rm.Pattern)
+	}
+
+	pw.family("xrayhq_dependency_calls_total", "counter", "DB queries, Redis and MongoDB operations and external calls made by requests, by route.")
+	for _, rm := range routes {
+		for _, d := range routeDependencies(rm) {
+			pw.sample("xrayhq_dependency_calls_total", d.calls, "method", rm.Method, "route", rm.Pattern, "dependency", d.name)
+		}
+	}
+	pw.family("xrayhq_dependency_duration_seconds_total", "counter", "Time requests spent in dependency calls, by route.")
+	for _, rm := range routes {
+		for _, d := range routeDependencies(rm) {
+			pw.sample("xrayhq_dependency_duration_seconds_total", d.time.Seconds(), "method", rm.Method, "route", rm.Pattern, "dependency", d.name)
+		}
+	}
+
+	totals := c.alertGroups.typeTotals()
+	pw.family("xrayhq_alerts_total", "counter", "Alerts fired by type.")
+	for _, t := range sortedKeys(totals) {
+		pw.sample("xrayhq_alerts_total", totals[t], "type", t)
+	}
+	active := make(map[string]int64)
+	for _, g := range c.AlertGroups() {
+		if g.Active() {
+			active[g.Type]++
+		}
+	}
+	pw.family("xrayhq_alerts_active", "gauge", "Firing or acknowledged alert groups by type.")
+	for _, t := range sortedKeys(active) {
+		pw.sample("xrayhq_alerts_active", active[t], "type", t)
+	}
+
+	pw.family("xrayhq_buffer_traces", "gauge", "Traces currently stored.")
+	pw.sample("xrayhq_buffer_traces", c.RequestCount())
+	switch s := c.store.(type) {
+	case *memoryStore:
+		bytes, budget := s.Usage()
+		pw.family("xrayhq_buffer_bytes", "gauge", "Estimated memory held by stored traces.")
+		pw.sample("xrayhq_buffer_bytes", bytes)
+		if s.opts.MaxTraces > 0 {
+			pw.family("xrayhq_buffer_max_traces", "gauge", "Maximum number of traces kept.")
+			pw.sample("xrayhq_buffer_max_traces", s.opts.MaxTraces)
+		}
+		if budget > 0 {
+			pw.family("xrayhq_buffer_max_bytes", "gauge", "Memory budget for stored traces.")
+			pw.sample("xrayhq_buffer_max_bytes", budget)
+		}
+	case *DiskStore:
+		pw.family("xrayhq_buffer_bytes", "gauge", "Size of the trace store's segment files.")
+		pw.sample("xrayhq_buffer_bytes", s.Size())
+	}
+
+	pw.family("xrayhq_routes", "gauge", "Routes currently tracked.")
+	pw.sample("xrayhq_routes", c.RouteCount())
+	pw.family("xrayhq_routes_collapsed_total", "counter", "Requests counted under the overflow route because the route table was full.")
+	pw.sample("xrayhq_routes_collapsed_total", c.CollapsedRoutes())
+	pw.family("xrayhq_routes_evicted_total", "counter", "Idle routes evicted to make room for new ones.")
+	pw.sample("xrayhq_routes_evicted_total", c.EvictedRoutes())
+	pw.family("xrayhq_sampled_out_total", "counter", "Traces dropped by tail or adaptive sampling.")
+	pw.sample("xrayhq_sampled_out_total", c.SampledOut())
+	pw.family("xrayhq_late_ops_total", "counter", "Operations dropped because they finished after their request.")
+	pw.sample("xrayhq_late_ops_total", c.LateOps())
+	pw.family("xrayhq_store_errors_total", "counter", "Failed trace store operations.")
+	pw.sample("xrayhq_store_errors_total", c.StoreErrors())
+	pw.family("xrayhq_otlp_dropped_total", "counter", "Traces not exported because the OTLP queue was full or export had shut down.")
+	pw.sample("xrayhq_otlp_dropped_total", c.ExportDropped())
+	pw.family("xrayhq_otlp_failed_total", "counter", "Traces in OTLP batches that failed after all retries.")
+	pw.sample("xrayhq_otlp_failed_total", c.ExportFailed())
+
+	return pw.flush()
+}
+
+type routeDependency struct {
+	name  string
+	calls int64
+	time  time.Duration
+}
+
+func routeDependencies(rm *RouteMetrics) []routeDependency {
+	return []routeDependency{
+		{"db", rm.DBQueries, rm.DBTime},
+		{"redis", rm.RedisOps, rm.RedisTime},
+		{"mongo", rm.MongoOps, rm.MongoTime},
+		{"external", rm.ExternalCalls, rm.ExternalTime},
+	}
+}
+
+// statusClass returns the class of an HTTP status code, such as "2xx".
+func statusClass(code int) string {
+	if code < 100 || code > 599 {
+		return "other"
+	}
+	return strconv.Itoa(code/100) + "xx"
+}
+
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// promWriter writes metric families in the Prometheus text format, keeping
+// the first write error.
+type promWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (pw *promWriter) family(name, typ, help string) {
+	pw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes one sample. labels are name, value pairs.
+func (pw *promWriter) sample(name string, value interface{}, labels ...string) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labels[i])
+			b.WriteString(`="`)
+			b.WriteString(promLabelEscaper.Replace(labels[i+1]))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	switch v := value.(type) {
+	case float64:
+		pw.printf("%s %s\n", b.String(), formatFloat(v))
+	default:
+		pw.printf("%s %d\n", b.String(), v)
+	}
+}
+
+func (pw *promWriter) printf(format string, args ...interface{}) {
+	if pw.err == nil {
+		_, pw.err = fmt.Fprintf(pw.w, format, args...)
+	}
+}
+
+func (pw *promWriter) flush() error {
+	if pw.err != nil {
+		return pw.err
+	}
+	return pw.w.Flush()
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
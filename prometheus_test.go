@@ -0,0 +1,82 @@
+package xrayhq
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	c, cfg := setupTestCollector()
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		status := 200
+		if i%5 == 0 {
+			status = 503
+		}
+		c.Record(&RequestTrace{
+			ID:             "m-" + strconv.Itoa(i),
+			Method:         "GET",
+			Path:           "/orders/1",
+			RoutePattern:   "/orders/{id}",
+			ResponseStatus: status,
+			Latency:        time.Duration(i+1) * 20 * time.Millisecond,
+			StartTime:      now,
+			DBQueries:      []DBQuery{{Query: "SELECT 1", Duration: 5 * time.Millisecond}, {Query: "SELECT 2", Duration: 5 * time.Millisecond}},
+			TotalDBTime:    10 * time.Millisecond,
+			RedisOps:       []RedisOp{{Command: "GET", Duration: time.Millisecond}},
+			TotalRedisTime: time.Millisecond,
+		})
+	}
+	c.Record(&RequestTrace{ID: "q", Method: "GET", RoutePattern: `/say/"hi"`, ResponseStatus: 404, Latency: time.Millisecond, StartTime: now})
+	c.alertEngine.Evaluate(slowQueryTrace("/orders/{id}", "SELECT * FROM orders"))
+
+	rec := httptest.NewRecorder()
+	newDashboardHandler(c, cfg).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != 200 || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected response %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		`xrayhq_requests_total{method="GET",route="/orders/{id}",status="2xx"} 8`,
+		`xrayhq_requests_total{method="GET",route="/orders/{id}",status="5xx"} 2`,
+		`xrayhq_requests_total{method="GET",route="/say/\"hi\"",status="4xx"} 1`,
+		`xrayhq_request_duration_seconds_bucket{method="GET",route="/orders/{id}",le="0.1"} 5`,
+		`xrayhq_request_duration_seconds_bucket{method="GET",route="/orders/{id}",le="+Inf"} 10`,
+		`xrayhq_request_duration_seconds_sum{method="GET",route="/orders/{id}"} 1.1`,
+		`xrayhq_dependency_calls_total{method="GET",route="/orders/{id}",dependency="db"} 20`,
+		`xrayhq_dependency_calls_total{method="GET",route="/orders/{id}",dependency="redis"} 10`,
+		`xrayhq_dependency_duration_seconds_total{method="GET",route="/orders/{id}",dependency="db"} 0.1`,
+		`xrayhq_alerts_total{type="slow_query"} 1`,
+		`xrayhq_alerts_active{type="slow_query"} 1`,
+		`xrayhq_buffer_traces 11`,
+		`xrayhq_routes 2`,
+		"# TYPE xrayhq_request_duration_seconds histogram",
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("expected %q in:\n%s", want, body)
+		}
+	}
+
+	sample := regexp.MustCompile(`^[a-z_]+(\{[a-z]+="(?:[^"\\]|\\.)*"(,[a-z]+="(?:[^"\\]|\\.)*")*\})? [0-9.e+-]+$`)
+	var last float64
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !sample.MatchString(line) {
+			t.Errorf("malformed sample %q", line)
+		}
+		if strings.HasPrefix(line, `xrayhq_request_duration_seconds_bucket{method="GET",route="/orders/{id}"`) {
+			v, _ := strconv.ParseFloat(line[strings.LastIndexByte(line, ' ')+1:], 64)
+			if v < last {
+				t.Errorf("histogram buckets not cumulative at %q", line)
+			}
+			last = v
+		}
+	}
+}
@@ -0,0 +1,440 @@
+package xrayhq
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query is a parsed trace search, such as
+//
+//	method:POST route:"/orders" status>=500 db.count>20 since:10m
+//
+// Terms are separated by spaces and must all match. A term is a field, an
+// operator (":" or "=" for equality, "!=", ">", ">=", "<", "<=") and a
+// value; a leading "-" negates it, and a term without an operator matches
+// the request path as a substring. Values may be quoted. String values may
+// use "*" as a wildcard; on path and route it stays within one segment and
+// "**" crosses segments, as in sampling rules.
+//
+// Fields:
+//
+//	id, trace, method, path, route, ip, ua, query, reason  strings
+//	status                       status code, or a class such as 5xx
+//	latency (or duration), ttfb  durations; bare numbers are milliseconds
+//	db.count, db.time            likewise redis.*, mongo.* and http.* for
+//	                             outgoing HTTP calls
+//	db.query                     any SQL statement of the request
+//	req.size, resp.size          bytes, with optional kb or mb suffix
+//	panic                        true or false
+//	alert, alerts.count          an alert type raised by the request
+//	span                         the name of any span
+//	header.NAME, resp.header.NAME  request and response header values
+//	since, until                 a duration ago, such as 10m, or RFC 3339
+type Query struct {
+	raw    string
+	terms  []func(*RequestTrace) bool
+	filter TraceFilter
+}
+
+// ParseQuery parses a search query. An empty query matches every trace.
+func ParseQuery(q string) (*Query, error) {
+	return parseQueryAt(q, time.Now())
+}
+
+func parseQueryAt(q string, now time.Time) (*Query, error) {
+	tokens, err := splitQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	query := &Query{raw: strings.TrimSpace(q)}
+	for _, tok := range tokens {
+		if err := query.addTerm(tok, now); err != nil {
+			return nil, err
+		}
+	}
+	return query, nil
+}
+
+// String returns the query as it was written.
+func (q *Query) String() string { return q.raw }
+
+// Filter returns the part of the query that Store.Iterate can use to narrow
+// its scan. Match must still be applied to its results.
+func (q *Query) Filter() TraceFilter { return q.filter }
+
+// Match reports whether t satisfies every term of the query.
+func (q *Query) Match(t *RequestTrace) bool {
+	if !q.filter.Match(t) {
+		return false
+	}
+	for _, term := range q.terms {
+		if !term(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitQuery splits q on spaces outside double quotes, removing the quotes.
+func splitQuery(q string) ([]string, error) {
+	var tokens []string
+	var cur strings.Builder
+	inToken, inQuote := false, false
+	for i := 0; i < len(q); i++ {
+		ch := q[i]
+		switch {
+		case inQuote && ch == '\\' && i+1 < len(q):
+			i++
+			cur.WriteByte(q[i])
+		case ch == '"':
+			inQuote = !inQuote
+			inToken = true
+		case !inQuote && (ch == ' ' || ch == '\t' || ch == '\n'):
+			if inToken {
+				tokens = append(tokens, cur.String())
+				cur.Reset()
+				inToken = false
+			}
+		default:
+			cur.WriteByte(ch)
+			inToken = true
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("unterminated quote in %q", q)
+	}
+	if inToken {
+		tokens = append(tokens, cur.String())
+	}
+	return tokens, nil
+}
+
+var queryOps = []string{"!=", ">=", "<=", ":", "=", ">", "<"}
+
+// splitTerm splits "field>=value" into its parts. ok is false for a term
+// without an operator.
+func splitTerm(tok string) (field, op, value string, ok bool) {
+	for i := 0; i < len(tok); i++ {
+		for _, o := range queryOps {
+			if strings.HasPrefix(tok[i:], o) {
+				return tok[:i], o, tok[i+len(o):], i > 0
+			}
+		}
+	}
+	return "", "", tok, false
+}
+
+func (q *Query) addTerm(tok string, now time.Time) error {
+	negate := false
+	if len(tok) > 1 && tok[0] == '-' {
+		negate, tok = true, tok[1:]
+	}
+	field, op, value, ok := splitTerm(tok)
+	if !ok {
+		text := strings.ToLower(tok)
+		q.add(negate, func(t *RequestTrace) bool { return strings.Contains(strings.ToLower(t.Path), text) })
+		return nil
+	}
+	field = strings.ToLower(field)
+
+	if op == "!=" {
+		negate, op = !negate, "="
+	}
+	if op == ":" {
+		op = "="
+	}
+
+	switch {
+	case field == "since" || field == "until":
+		return q.addTime(field, op, value, negate, now)
+	case field == "status":
+		return q.addStatus(op, value, negate)
+	case strings.HasPrefix(field, "header."):
+		name := field[len("header."):]
+		return q.addString(tok, op, value, negate, false, func(t *RequestTrace) []string { return []string{headerValue(t.RequestHeaders, name)} })
+	case strings.HasPrefix(field, "resp.header."):
+		name := field[len("resp.header."):]
+		return q.addString(tok, op, value, negate, false, func(t *RequestTrace) []string { return []string{headerValue(t.ResponseHeaders, name)} })
+	}
+
+	if get, ok := queryStringFields[field]; ok {
+		pathLike := field == "path" || field == "route"
+		if field == "method" {
+			value = strings.ToUpper(value)
+			if op == "=" && !negate && !strings.Contains(value, "*") && q.filter.Method == "" {
+				q.filter.Method = value
+				return nil
+			}
+		}
+		if field == "route" && op == "=" && !negate && !strings.Contains(value, "*") && q.filter.RoutePattern == "" {
+			q.filter.RoutePattern = value
+			return nil
+		}
+		return q.addString(tok, op, value, negate, pathLike, get)
+	}
+	if get, ok := queryDurationFields[field]; ok {
+		d, err := parseQueryDuration(value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", tok, err)
+		}
+		return q.addCompare(tok, op, negate, func(t *RequestTrace) int64 { return int64(get(t)) }, int64(d))
+	}
+	if get, ok := queryIntFields[field]; ok {
+		n, err := parseQuerySize(value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", tok, err)
+		}
+		return q.addCompare(tok, op, negate, get, n)
+	}
+	if field == "panic" {
+		b, err := strconv.ParseBool(value)
+		if err != nil || op != "=" {
+			return fmt.Errorf("%s: panic takes true or false", tok)
+		}
+		q.add(negate, func(t *RequestTrace) bool { return t.Panicked == b })
+		return nil
+	}
+	return fmt.Errorf("unknown field %q", field)
+}
+
+func (q *Query) add(negate bool, match func(*RequestTrace) bool) {
+	if negate {
+		q.terms = append(q.terms, func(t *RequestTrace) bool { return !match(t) })
+		return
+	}
+	q.terms = append(q.terms, match)
+}
+
+// addString adds a term that matches if any of the values get returns
+// matches value.
+func (q *Query) addString(tok, op, value string, negate, pathLike bool, get func(*RequestTrace) []string) error {
+	if op != "=" {
+		return fmt.Errorf("%s: only =, : and != apply to text", tok)
+	}
+	match := func(s string) bool { return s == value }
+	if strings.Contains(value, "*") {
+		if pathLike {
+			match = func(s string) bool { return globMatch(value, s) }
+		} else {
+			match = func(s string) bool { return wildcardMatch(value, s) }
+		}
+	}
+	q.add(negate, func(t *RequestTrace) bool {
+		for _, s := range get(t) {
+			if match(s) {
+				return true
+			}
+		}
+		return false
+	})
+	return nil
+}
+
+func (q *Query) addCompare(tok, op string, negate bool, get func(*RequestTrace) int64, want int64) error {
+	var cmp func(int64) bool
+	switch op {
+	case "=":
+		cmp = func(n int64) bool { return n == want }
+	case ">":
+		cmp = func(n int64) bool { return n > want }
+	case ">=":
+		cmp = func(n int64) bool { return n >= want }
+	case "<":
+		cmp = func(n int64) bool { return n < want }
+	case "<=":
+		cmp = func(n int64) bool { return n <= want }
+	default:
+		return fmt.Errorf("%s: unsupported operator %q", tok, op)
+	}
+	q.add(negate, func(t *RequestTrace) bool { return cmp(get(t)) })
+	return nil
+}
+
+// addStatus narrows the store filter where it can, so the status index is
+// used, and falls back to a term otherwise. TraceFilter takes a zero bound
+// to mean no bound, so zero bounds need a term too.
+func (q *Query) addStatus(op, value string, negate bool) error {
+	var lo, hi int
+	var hasLo, hasHi bool
+	if len(value) == 3 && strings.HasSuffix(strings.ToLower(value), "xx") && value[0] >= '1' && value[0] <= '5' {
+		if op != "=" {
+			return fmt.Errorf("status:%s: classes only support =, : and !=", value)
+		}
+		lo = int(value[0]-'0') * 100
+		hi = lo + 99
+		hasLo, hasHi = true, true
+	} else {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("status: %q is not a status code", value)
+		}
+		switch op {
+		case "=":
+			lo, hi = n, n
+			hasLo, hasHi = true, true
+		case ">":
+			lo, hasLo = n+1, true
+		case ">=":
+			lo, hasLo = n, true
+		case "<":
+			hi, hasHi = n-1, true
+		case "<=":
+			hi, hasHi = n, true
+		}
+	}
+	match := func(t *RequestTrace) bool {
+		return (!hasLo || t.ResponseStatus >= lo) && (!hasHi || t.ResponseStatus <= hi)
+	}
+
+	if negate {
+		q.add(true, match)
+		return nil
+	}
+	if (hasLo && lo == 0) || (hasHi && hi == 0) {
+		q.add(false, match)
+		return nil
+	}
+	if hasLo && lo > q.filter.MinStatus {
+		q.filter.MinStatus = lo
+	}
+	if hasHi && (q.filter.MaxStatus == 0 || hi < q.filter.MaxStatus) {
+		q.filter.MaxStatus = hi
+	}
+	return nil
+}
+
+func (q *Query) addTime(field, op, value string, negate bool, now time.Time) error {
+	if op != "=" || negate {
+		return fmt.Errorf("%s: use %s:VALUE", field, field)
+	}
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		d, derr := time.ParseDuration(value)
+		if derr != nil {
+			return fmt.Errorf("%s: %q is neither a duration nor an RFC 3339 time", field, value)
+		}
+		at = now.Add(-d)
+	}
+	if field == "since" {
+		q.filter.Since = at
+	} else {
+		q.filter.Until = at
+	}
+	return nil
+}
+
+func headerValue(headers map[string]string, name string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+// wildcardMatch matches s against a pattern where "*" matches any run of
+// characters.
+func wildcardMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for i, part := range parts[1:] {
+		if i == len(parts)-2 {
+			return strings.HasSuffix(s, part)
+		}
+		j := strings.Index(s, part)
+		if j < 0 {
+			return false
+		}
+		s = s[j+len(part):]
+	}
+	return true
+}
+
+// parseQueryDuration parses a Go duration, treating a bare number as
+// milliseconds.
+func parseQueryDuration(s string) (time.Duration, error) {
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(n * float64(time.Millisecond)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// parseQuerySize parses a count or a byte size with an optional b, kb or mb
+// suffix.
+func parseQuerySize(s string) (int64, error) {
+	lower := strings.ToLower(s)
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(lower, "kb"):
+		mult, lower = 1024, lower[:len(lower)-2]
+	case strings.HasSuffix(lower, "mb"):
+		mult, lower = 1024*1024, lower[:len(lower)-2]
+	case strings.HasSuffix(lower, "b"):
+		lower = lower[:len(lower)-1]
+	}
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	return n * mult, nil
+}
+
+func one(s string) []string { return []string{s} }
+
+var queryStringFields = map[string]func(*RequestTrace) []string{
+	"id":     func(t *RequestTrace) []string { return one(t.ID) },
+	"trace":  func(t *RequestTrace) []string { return one(t.TraceID) },
+	"method": func(t *RequestTrace) []string { return one(t.Method) },
+	"path":   func(t *RequestTrace) []string { return one(t.Path) },
+	"route":  func(t *RequestTrace) []string { return one(t.RoutePattern) },
+	"ip":     func(t *RequestTrace) []string { return one(t.ClientIP) },
+	"ua":     func(t *RequestTrace) []string { return one(t.UserAgent) },
+	"query":  func(t *RequestTrace) []string { return one(t.QueryParams) },
+	"reason": func(t *RequestTrace) []string { return one(t.SampleReason) },
+	"db.query": func(t *RequestTrace) []string {
+		out := make([]string, len(t.DBQueries))
+		for i, q := range t.DBQueries {
+			out[i] = q.Query
+		}
+		return out
+	},
+	"alert": func(t *RequestTrace) []string {
+		out := make([]string, len(t.Alerts))
+		for i, a := range t.Alerts {
+			out[i] = a.Type
+		}
+		return out
+	},
+	"span": func(t *RequestTrace) []string {
+		out := make([]string, len(t.Spans))
+		for i, s := range t.Spans {
+			out[i] = s.Name
+		}
+		return out
+	},
+}
+
+var queryDurationFields = map[string]func(*RequestTrace) time.Duration{
+	"latency":    func(t *RequestTrace) time.Duration { return t.Latency },
+	"duration":   func(t *RequestTrace) time.Duration { return t.Latency },
+	"ttfb":       func(t *RequestTrace) time.Duration { return t.TTFB },
+	"db.time":    func(t *RequestTrace) time.Duration { return t.TotalDBTime },
+	"redis.time": func(t *RequestTrace) time.Duration { return t.TotalRedisTime },
+	"mongo.time": func(t *RequestTrace) time.Duration { return t.TotalMongoTime },
+	"http.time":  func(t *RequestTrace) time.Duration { return t.TotalExtTime },
+}
+
+var queryIntFields = map[string]func(*RequestTrace) int64{
+	"db.count":     func(t *RequestTrace) int64 { return int64(len(t.DBQueries)) },
+	"redis.count":  func(t *RequestTrace) int64 { return int64(len(t.RedisOps)) },
+	"mongo.count":  func(t *RequestTrace) int64 { return int64(len(t.MongoOps)) },
+	"http.count":   func(t *RequestTrace) int64 { return int64(len(t.ExternalCalls)) },
+	"alerts.count": func(t *RequestTrace) int64 { return int64(len(t.Alerts)) },
+	"req.size":     func(t *RequestTrace) int64 { return t.RequestSize },
+	"resp.size":    func(t *RequestTrace) int64 { return t.ResponseSize },
+}
@@ -0,0 +1,166 @@
+package xrayhq
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryMatch(t *testing.T) {
+	now := time.Now()
+	trace := &RequestTrace{
+		ID:             "q1",
+		Method:         "POST",
+		Path:           "/orders/42",
+		RoutePattern:   "/orders/{id}",
+		ResponseStatus: 502,
+		Latency:        300 * time.Millisecond,
+		StartTime:      now.Add(-5 * time.Minute),
+		RequestHeaders: map[string]string{"X-Tenant": "acme"},
+		UserAgent:      "Mozilla/5.0 (X11) Chrome/120",
+		DBQueries:      make([]DBQuery, 21),
+		TotalRedisTime: 60 * time.Millisecond,
+		ResponseSize:   2048,
+		Alerts:         []Alert{{Type: "n_plus_one"}},
+	}
+
+	cases := map[string]bool{
+		``: true,
+		`method:post route:"/orders/{id}" status>=500 db.count>20`: true,
+		`status:5xx redis.time>50ms header.x-tenant=acme`:          true,
+		`since:10m`:                  true,
+		`since:1m`:                   false,
+		`status<500`:                 false,
+		`-status:5xx`:                false,
+		`status!=404`:                true,
+		`status<1`:                   false,
+		`status:0`:                   false,
+		`-status<1`:                  true,
+		`latency>=300`:               true,
+		`latency>1s`:                 false,
+		`route:/orders/*`:            true,
+		`path:/orders/*`:             true,
+		`path:/*`:                    false,
+		`ua:*Chrome*`:                true,
+		`orders`:                     true,
+		`-orders`:                    false,
+		`alert:n_plus_one`:           true,
+		`resp.size>=2kb`:             true,
+		`panic:true`:                 false,
+		`header.X-Tenant!=acme`:      false,
+		`method:GET`:                 false,
+		`method:POST method:GET`:     false,
+		`redis.count=0 mongo.time<1`: true,
+	}
+	for query, want := range cases {
+		q, err := parseQueryAt(query, now)
+		if err != nil {
+			t.Errorf("%q: %v", query, err)
+			continue
+		}
+		if got := q.Match(trace); got != want {
+			t.Errorf("%q: got %v, want %v", query, got, want)
+		}
+	}
+}
+
+func TestQueryFilterPushdown(t *testing.T) {
+	q, err := ParseQuery(`method:get route:/x status>=500 status<=503 since:1h`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := q.Filter()
+	if f.Method != "GET" || f.RoutePattern != "/x" || f.MinStatus != 500 || f.MaxStatus != 503 || f.Since.IsZero() {
+		t.Errorf("expected indexable terms in the store filter, got %+v", f)
+	}
+}
+
+func TestQueryZeroStatusBound(t *testing.T) {
+	c, _ := setupTestCollector()
+	c.Record(&RequestTrace{ID: "ok", Method: "GET", RoutePattern: "/p", ResponseStatus: 200, StartTime: time.Now()})
+	c.Record(&RequestTrace{ID: "none", Method: "GET", RoutePattern: "/p", StartTime: time.Now()})
+
+	for query, want := range map[string]int{"status<1": 1, "status:0": 1, "status<=0": 1, "status>=0": 2, "status<200": 1} {
+		q, err := ParseQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := c.Search(q, 0, 10); len(got) != want {
+			t.Errorf("%q: expected %d results, got %d", query, want, len(got))
+		}
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	for _, query := range []string{
+		`nosuch:1`,
+		`status:abc`,
+		`latency>fast`,
+		`route>"/x"`,
+		`since:yesterday`,
+		`path:"/unterminated`,
+		`panic:maybe`,
+	} {
+		if _, err := ParseQuery(query); err == nil {
+			t.Errorf("%q: expected an error", query)
+		}
+	}
+}
+
+func TestCollectorSearchPagination(t *testing.T) {
+	c, _ := setupTestCollector()
+	for i := 0; i < 30; i++ {
+		status := 200
+		if i%3 == 0 {
+			status = 500
+		}
+		c.Record(&RequestTrace{ID: fmt.Sprint("s", i), Method: "GET", RoutePattern: "/p", ResponseStatus: status, StartTime: time.Now()})
+	}
+
+	q, _ := ParseQuery("status:5xx")
+	page1, more := c.Search(q, 0, 6)
+	if len(page1) != 6 || !more || page1[0].ID != "s27" {
+		t.Fatalf("unexpected first page: %d results, more=%v", len(page1), more)
+	}
+	page2, more := c.Search(q, 6, 6)
+	if len(page2) != 4 || more || page2[3].ID != "s0" {
+		t.Errorf("unexpected last page: %d results, more=%v", len(page2), more)
+	}
+}
+
+func TestSearchEndpoints(t *testing.T) {
+	c, cfg := setupTestCollector()
+	c.Record(&RequestTrace{ID: "hit", Method: "POST", Path: "/orders", RoutePattern: "/orders", ResponseStatus: 500, StartTime: time.Now()})
+	c.Record(&RequestTrace{ID: "miss", Method: "GET", Path: "/orders", RoutePattern: "/orders", ResponseStatus: 200, StartTime: time.Now()})
+	handler := newDashboardHandler(c, cfg)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/search?q="+url.QueryEscape(`method:POST status>=500`), nil))
+	var resp struct {
+		More    bool
+		Results []RequestTrace
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) != 1 || resp.Results[0].ID != "hit" {
+		t.Errorf("unexpected API results %+v", resp.Results)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/search?q=bogus:1", nil))
+	if rec.Code != 400 || !strings.Contains(rec.Body.String(), "unknown field") {
+		t.Errorf("expected a 400 for a bad query, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/search?q="+url.QueryEscape(`status:5xx`), nil))
+	body := rec.Body.String()
+	if rec.Code != 200 || !strings.Contains(body, "/request/hit") || strings.Contains(body, "/request/miss") {
+		t.Errorf("expected search page to list only matching requests, got %d", rec.Code)
+	}
+}
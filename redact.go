@@ -0,0 +1,393 @@
+package xrayhq
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// RedactionConfig controls which captured data is masked before a trace is
+// stored, shown on the dashboard or exported. Names are matched
+// case-insensitively.
+type RedactionConfig struct {
+	// Headers lists request and response headers whose values are masked.
+	Headers []string
+	// BodyFields lists JSON and form fields to mask. A bare name such as
+	// "password" matches the field at any depth; a dotted path such as
+	// "user.card.number" matches from the root, with "*" matching any key
+	// or array index.
+	BodyFields []string
+	// QueryParams lists query string parameters to mask, in the request URL
+	// and in outbound call URLs.
+	QueryParams []string
+	// SQLLiterals replaces string and numeric literals in captured SQL
+	// with ?. Only single-quoted strings are treated as literals, since
+	// double quotes delimit identifiers in standard SQL and PostgreSQL. A
+	// MySQL query written with double-quoted strings, such as
+	// WHERE email = "a@b.c", keeps them; pass values as bind parameters
+	// instead.
+	SQLLiterals bool
+	// MongoFilters replaces the values in captured Mongo filters with ?.
+	MongoFilters bool
+	// RedisKeys masks everything after the first ':' of Redis keys, so
+	// "session:abc123" is kept as "session:?".
+	RedisKeys bool
+	// Mask replaces redacted values. Defaults to "[REDACTED]".
+	Mask string
+}
+
+// DefaultRedactionConfig returns the redaction applied unless configured
+// otherwise: credentials in headers, common secret fields in bodies and query
+// strings, SQL and Mongo literals, and Redis key suffixes.
+func DefaultRedactionConfig() RedactionConfig {
+	secrets := []string{
+		"password", "passwd", "secret", "token", "access_token", "refresh_token",
+		"id_token", "api_key", "apikey", "client_secret", "credit_card",
+		"card_number", "cvv", "ssn",
+	}
+	return RedactionConfig{
+		Headers: []string{
+			"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie",
+			"X-Api-Key", "X-Auth-Token", "X-Csrf-Token",
+		},
+		BodyFields:   secrets,
+		QueryParams:  append([]string{"key", "sig", "signature", "code"}, secrets...),
+		SQLLiterals:  true,
+		MongoFilters: true,
+		RedisKeys:    true,
+		Mask:         "[REDACTED]",
+	}
+}
+
+// redactor applies a RedactionConfig to traces. It is built once per
+// collector so name lookups are precomputed.
+type redactor struct {
+	mask         string
+	headers      map[string]bool
+	fields       map[string]bool // bare field names
+	paths        [][]string      // dotted field paths
+	params       map[string]bool
+	sqlLiterals  bool
+	mongoFilters bool
+	redisKeys    bool
+}
+
+func newRedactor(cfg RedactionConfig) *redactor {
+	r := &redactor{
+		mask:         cfg.Mask,
+		headers:      lowerSet(cfg.Headers),
+		fields:       make(map[string]bool),
+		params:       lowerSet(cfg.QueryParams),
+		sqlLiterals:  cfg.SQLLiterals,
+		mongoFilters: cfg.MongoFilters,
+		redisKeys:    cfg.RedisKeys,
+	}
+	if r.mask == "" {
+		r.mask = "[REDACTED]"
+	}
+	for _, f := range cfg.BodyFields {
+		f = strings.ToLower(f)
+		if strings.Contains(f, ".") {
+			r.paths = append(r.paths, strings.Split(f, "."))
+		} else {
+			r.fields[f] = true
+		}
+	}
+	return r
+}
+
+func lowerSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, n := range names {
+		set[strings.ToLower(n)] = true
+	}
+	return set
+}
+
+// apply redacts t in place. It must run before t is shared.
+func (r *redactor) apply(t *RequestTrace) {
+	r.redactHeaders(t.RequestHeaders)
+	r.redactHeaders(t.ResponseHeaders)
+	t.QueryParams = r.redactQuery(t.QueryParams)
+	t.RequestBody = r.redactBody(t.RequestBody)
+	t.ResponseBody = r.redactBody(t.ResponseBody)
+
+	for i := range t.DBQueries {
+		if r.sqlLiterals {
+			t.DBQueries[i].Query = redactSQL(t.DBQueries[i].Query)
+		}
+	}
+	for i := range t.ExternalCalls {
+		t.ExternalCalls[i].URL = r.redactURL(t.ExternalCalls[i].URL)
+	}
+	for i := range t.RedisOps {
+		if r.redisKeys {
+			t.RedisOps[i].Key = redactRedisKey(t.RedisOps[i].Key)
+		}
+	}
+	for i := range t.MongoOps {
+		if r.mongoFilters && t.MongoOps[i].Filter != "" {
+			t.MongoOps[i].Filter = redactMongoFilter(t.MongoOps[i].Filter)
+		}
+	}
+}
+
+func (r *redactor) redactHeaders(h map[string]string) {
+	for k := range h {
+		if r.headers[strings.ToLower(k)] {
+			h[k] = r.mask
+		}
+	}
+}
+
+// redactQuery masks parameters in a raw query string, leaving the order and
+// encoding of the other parameters untouched.
+func (r *redactor) redactQuery(raw string) string {
+	if raw == "" || len(r.params) == 0 {
+		return raw
+	}
+	pairs := strings.Split(raw, "&")
+	changed := false
+	for i, pair := range pairs {
+		key, _, _ := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		if r.params[strings.ToLower(name)] {
+			pairs[i] = key + "=" + r.mask
+			changed = true
+		}
+	}
+	if !changed {
+		return raw
+	}
+	return strings.Join(pairs, "&")
+}
+
+func (r *redactor) redactURL(raw string) string {
+	base, query, ok := strings.Cut(raw, "?")
+	if !ok {
+		return raw
+	}
+	query, fragment, hasFragment := strings.Cut(query, "#")
+	out := base + "?" + r.redactQuery(query)
+	if hasFragment {
+		out += "#" + fragment
+	}
+	return out
+}
+
+// redactBody masks fields in JSON and form-encoded bodies. Bodies that were
+// truncated mid-document are masked field by field with a pattern match.
+func (r *redactor) redactBody(body []byte) []byte {
+	if len(body) == 0 || (len(r.fields) == 0 && len(r.paths) == 0) {
+		return body
+	}
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return r.redactJSON(body)
+	}
+	if isFormBody(trimmed) {
+		return []byte(r.redactForm(string(trimmed)))
+	}
+	return body
+}
+
+func (r *redactor) redactJSON(body []byte) []byte {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return r.redactJSONFragment(body)
+	}
+	if !r.walkJSON(v, nil) {
+		return body
+	}
+	out, err := marshalJSON(v)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+// marshalJSON encodes v like json.Marshal but keeps <, > and & literal
+// instead of escaping them. The output is re-encoded JSON: object keys come
+// out sorted and whitespace is dropped.
+func marshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// walkJSON masks matching fields of v in place and reports whether anything
+// was masked.
+func (r *redactor) walkJSON(v interface{}, path []string) bool {
+	changed := false
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, child := range val {
+			p := append(path, strings.ToLower(k))
+			if r.matchField(p) {
+				val[k] = r.mask
+				changed = true
+				continue
+			}
+			if r.walkJSON(child, p) {
+				changed = true
+			}
+		}
+	case []interface{}:
+		for _, child := range val {
+			// Array elements match "*" in dotted paths.
+			if r.walkJSON(child, append(path, "*")) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+func (r *redactor) matchField(path []string) bool {
+	if r.fields[path[len(path)-1]] {
+		return true
+	}
+	for _, p := range r.paths {
+		if len(p) != len(path) {
+			continue
+		}
+		match := true
+		for i := range p {
+			if p[i] != "*" && p[i] != path[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+var jsonFieldPattern = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"(\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\]\s]+)`)
+
+// redactJSONFragment masks "name": value pairs by field name in a body that
+// is not a complete JSON document, typically because capture truncated it.
+func (r *redactor) redactJSONFragment(body []byte) []byte {
+	return jsonFieldPattern.ReplaceAllFunc(body, func(m []byte) []byte {
+		sub := jsonFieldPattern.FindSubmatch(m)
+		if !r.fields[strings.ToLower(string(sub[1]))] {
+			return m
+		}
+		var b bytes.Buffer
+		b.WriteByte('"')
+		b.Write(sub[1])
+		b.WriteByte('"')
+		b.Write(sub[2])
+		b.WriteString(`"` + r.mask + `"`)
+		return b.Bytes()
+	})
+}
+
+func isFormBody(b []byte) bool {
+	return bytes.IndexByte(b, '=') > 0 && bytes.IndexAny(b, " \t\r\n{}<>") < 0
+}
+
+func (r *redactor) redactForm(body string) string {
+	pairs := strings.Split(body, "&")
+	changed := false
+	for i, pair := range pairs {
+		key, _, _ := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		if r.fields[strings.ToLower(name)] {
+			pairs[i] = key + "=" + r.mask
+			changed = true
+		}
+	}
+	if !changed {
+		return body
+	}
+	return strings.Join(pairs, "&")
+}
+
+var sqlLiteralPattern = regexp.MustCompile(`'(?:[^'\\]|''|\\.)*'|[$:@?]?\b\d+(?:\.\d+)?\b`)
+
+// redactSQL replaces single-quoted strings and numbers in a query with ?,
+// leaving bind placeholders such as $1, :1 and @p1 alone.
+func redactSQL(query string) string {
+	return sqlLiteralPattern.ReplaceAllStringFunc(query, func(lit string) string {
+		switch lit[0] {
+		case '$', ':', '@', '?':
+			return lit
+		}
+		return "?"
+	})
+}
+
+// mongoTypeWrappers are the extended JSON wrappers the driver uses for typed
+// values, such as {"$numberInt": "30"}.
+var mongoTypeWrappers = map[string]bool{
+	"$oid": true, "$date": true, "$numberInt": true, "$numberLong": true,
+	"$numberDouble": true, "$numberDecimal": true, "$binary": true,
+	"$regularExpression": true, "$timestamp": true, "$uuid": true,
+	"$symbol": true, "$code": true,
+}
+
+// redactMongoFilter replaces every value in an extended JSON filter with ?,
+// keeping field names and query operators so the filter's shape is still
+// visible.
+func redactMongoFilter(filter string) string {
+	var v interface{}
+	if err := json.Unmarshal([]byte(filter), &v); err != nil {
+		return "?"
+	}
+	out, err := marshalJSON(maskMongoValues(v))
+	if err != nil {
+		return "?"
+	}
+	return string(out)
+}
+
+func maskMongoValues(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		if len(val) == 1 {
+			for k := range val {
+				if mongoTypeWrappers[k] {
+					return "?"
+				}
+			}
+		}
+		for k, child := range val {
+			val[k] = maskMongoValues(child)
+		}
+		return val
+	case []interface{}:
+		for i, child := range val {
+			val[i] = maskMongoValues(child)
+		}
+		return val
+	default:
+		return "?"
+	}
+}
+
+func redactRedisKey(key string) string {
+	if key == "" {
+		return key
+	}
+	if prefix, _, ok := strings.Cut(key, ":"); ok {
+		return prefix + ":?"
+	}
+	return "?"
+}
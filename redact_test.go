@@ -0,0 +1,131 @@
+package xrayhq
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRedactJSONBody(t *testing.T) {
+	r := newRedactor(RedactionConfig{BodyFields: []string{"password", "user.card.number", "items.*.token"}})
+
+	body := `{"user":{"name":"ann","password":"hunter2","card":{"number":"4111","exp":"12/30"}},"items":[{"token":"t1","id":1}],"card":{"number":"keep"}}`
+	got := string(r.redactBody([]byte(body)))
+
+	for _, secret := range []string{"hunter2", "4111", "t1"} {
+		if strings.Contains(got, secret) {
+			t.Errorf("expected %q to be masked in %s", secret, got)
+		}
+	}
+	for _, kept := range []string{"ann", "12/30", "keep", `"id":1`} {
+		if !strings.Contains(got, kept) {
+			t.Errorf("expected %q to be kept in %s", kept, got)
+		}
+	}
+
+	untouched := `{"name": "ann"}`
+	if string(r.redactBody([]byte(untouched))) != untouched {
+		t.Error("expected body without matches to be left byte for byte")
+	}
+
+	html := `{"password":"x","note":"<b>a & b</b>"}`
+	if got := string(r.redactBody([]byte(html))); !strings.Contains(got, `"<b>a & b</b>"`) {
+		t.Errorf("expected HTML characters left unescaped, got %s", got)
+	}
+}
+
+func TestRedactTruncatedJSONAndForm(t *testing.T) {
+	r := newRedactor(DefaultRedactionConfig())
+
+	truncated := `{"email":"a@b.c","password":"hunter2","bio":"long tex`
+	got := string(r.redactBody([]byte(truncated)))
+	if strings.Contains(got, "hunter2") || !strings.Contains(got, "a@b.c") {
+		t.Errorf("expected password masked in truncated JSON, got %s", got)
+	}
+
+	form := "user=ann&Password=hunter2&remember=1"
+	got = string(r.redactBody([]byte(form)))
+	if got != "user=ann&Password=[REDACTED]&remember=1" {
+		t.Errorf("unexpected form redaction: %s", got)
+	}
+}
+
+func TestRedactQueryAndURL(t *testing.T) {
+	r := newRedactor(DefaultRedactionConfig())
+
+	if got := r.redactQuery("page=2&access_token=abc&q=go"); got != "page=2&access_token=[REDACTED]&q=go" {
+		t.Errorf("unexpected query redaction: %s", got)
+	}
+	if got := r.redactURL("https://api.example.com/v1?key=secret&x=1#frag"); got != "https://api.example.com/v1?key=[REDACTED]&x=1#frag" {
+		t.Errorf("unexpected URL redaction: %s", got)
+	}
+	if got := r.redactURL("https://api.example.com/v1"); got != "https://api.example.com/v1" {
+		t.Errorf("expected URL without query unchanged, got %s", got)
+	}
+}
+
+func TestRedactSQL(t *testing.T) {
+	cases := map[string]string{
+		"SELECT * FROM users WHERE email = 'a@b.c' AND age > 30":     "SELECT * FROM users WHERE email = ? AND age > ?",
+		"SELECT * FROM t1 WHERE id = $1 AND name = 'O''Brien'":       "SELECT * FROM t1 WHERE id = $1 AND name = ?",
+		"UPDATE accounts SET balance = 10.50 WHERE id = :1":          "UPDATE accounts SET balance = ? WHERE id = :1",
+		"INSERT INTO logs (msg) VALUES (?)":                          "INSERT INTO logs (msg) VALUES (?)",
+		`SELECT * FROM notes WHERE body = 'it\'s secret' AND id = 7`: "SELECT * FROM notes WHERE body = ? AND id = ?",
+		`SELECT "name" FROM "users" WHERE id = 1`:                    `SELECT "name" FROM "users" WHERE id = ?`,
+	}
+	for in, want := range cases {
+		if got := redactSQL(in); got != want {
+			t.Errorf("redactSQL(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRedactMongoFilterAndRedisKey(t *testing.T) {
+	got := redactMongoFilter(`{"email": "a@b.c", "age": {"$gt": {"$numberInt": "30"}}, "tags": {"$in": ["x", "y"]}}`)
+	if strings.Contains(got, "a@b.c") || strings.Contains(got, "30") || strings.Contains(got, `"x"`) {
+		t.Errorf("expected filter values masked, got %s", got)
+	}
+	if !strings.Contains(got, `"$gt"`) || !strings.Contains(got, `"email"`) {
+		t.Errorf("expected filter shape kept, got %s", got)
+	}
+
+	if got := redactRedisKey("session:abc123"); got != "session:?" {
+		t.Errorf("unexpected redis key redaction: %s", got)
+	}
+}
+
+func TestRedactionAppliedBeforeExport(t *testing.T) {
+	c, cfg := setupTestCollector()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		AddDBQuery(r.Context(), DBQuery{Query: "SELECT * FROM users WHERE email = 'ann@example.com'"})
+		AddRedisOp(r.Context(), RedisOp{Command: "GET", Key: "session:deadbeef"})
+		w.Header().Set("Set-Cookie", "sid=deadbeef")
+		w.Write([]byte(`{"token":"deadbeef"}`))
+	})
+
+	req := httptest.NewRequest("POST", "/login?api_key=deadbeef", strings.NewReader(`{"user":"ann","password":"deadbeef"}`))
+	req.Header.Set("Authorization", "Bearer deadbeef")
+	req.Header.Set("Content-Type", "application/json")
+	coreMiddleware(c, cfg, handler).ServeHTTP(httptest.NewRecorder(), req)
+
+	ds := &DashboardServer{collector: c, config: cfg}
+	for _, format := range []string{"json", "csv"} {
+		rec := httptest.NewRecorder()
+		ds.handleExport(rec, httptest.NewRequest("GET", "/xrayhq/export?format="+format, nil))
+		if strings.Contains(rec.Body.String(), "deadbeef") || strings.Contains(rec.Body.String(), "ann@example.com") {
+			t.Errorf("%s export leaked a secret: %s", format, rec.Body.String())
+		}
+	}
+
+	trace := c.GetRecentRequests(1)[0]
+	if trace.RequestHeaders["Authorization"] != "[REDACTED]" {
+		t.Errorf("expected Authorization masked, got %q", trace.RequestHeaders["Authorization"])
+	}
+	if !strings.Contains(string(trace.RequestBody), "ann") {
+		t.Errorf("expected non-secret fields kept, got %s", trace.RequestBody)
+	}
+}
@@ -15,6 +15,12 @@ type responseWriter struct {
 	ttfb        time.Duration
 	startTime   time.Time
 	captureBody bool
+
+	// bodyLimit caps the captured body; 0 means no limit. allowBody, when
+	// set, decides from the response Content-Type whether to capture at all.
+	bodyLimit     int
+	bodyTruncated bool
+	allowBody     func(contentType string) bool
 }
 
 func newResponseWriter(w http.ResponseWriter, start time.Time, captureBody bool) *responseWriter {
@@ -31,6 +37,9 @@ func (rw *responseWriter) WriteHeader(code int) {
 		rw.statusCode = code
 		rw.wroteHeader = true
 		rw.ttfb = time.Since(rw.startTime)
+		if rw.captureBody && rw.allowBody != nil {
+			rw.captureBody = rw.allowBody(rw.Header().Get("Content-Type"))
+		}
 		rw.ResponseWriter.WriteHeader(code)
 	}
 }
@@ -41,8 +50,8 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	}
 	n, err := rw.ResponseWriter.Write(b)
 	rw.size += int64(n)
-	if rw.captureBody {
-		rw.body.Write(b[:n])
+	if rw.captureBody && appendCapped(&rw.body, b[:n], rw.bodyLimit) {
+		rw.bodyTruncated = true
 	}
 	return n, err
 }
@@ -0,0 +1,201 @@
+package xrayhq
+
+import "time"
+
+const (
+	// RollupResolution is the width of one route rollup bucket.
+	RollupResolution = time.Minute
+	// RollupRetention is how far back route rollups are kept. A route holds
+	// one RollupBucket, about 330 bytes once allocated, per minute with
+	// traffic, so a route busy around the clock takes about 470KB and
+	// Config.MaxRoutes such routes up to 470MB at the default of 1000.
+	RollupRetention = 24 * time.Hour
+
+	rollupSlots = int(RollupRetention / RollupResolution)
+
+	// recentWindow is the window Status judges a route's health on.
+	recentWindow = 5 * time.Minute
+)
+
+// RollupBucket summarizes a route's requests over one RollupResolution
+// interval. It has a fixed size of about 300 bytes.
+type RollupBucket struct {
+	Start             time.Time
+	Requests          int64
+	Errors            int64 // 5xx responses
+	EstimatedRequests float64
+	TotalLatency      time.Duration
+	DBTime            time.Duration
+	RedisTime         time.Duration
+	ExternalTime      time.Duration
+	Latency           LatencyHistogram
+}
+
+func (b *RollupBucket) record(trace *RequestTrace, weight float64) {
+	b.Requests++
+	b.EstimatedRequests += weight
+	if trace.ResponseStatus >= 500 {
+		b.Errors++
+	}
+	b.TotalLatency += trace.Latency
+	b.DBTime += trace.TotalDBTime
+	b.RedisTime += trace.TotalRedisTime
+	b.ExternalTime += trace.TotalExtTime
+	b.Latency.Add(trace.Latency)
+}
+
+// ErrorRate returns the percentage of requests in the bucket that failed.
+func (b RollupBucket) ErrorRate() float64 {
+	if b.Requests == 0 {
+		return 0
+	}
+	return float64(b.Errors) / float64(b.Requests) * 100
+}
+
+func (b RollupBucket) P95() time.Duration { return b.Latency.Quantile(0.95) }
+
+// routeRollups is a ring of per-minute buckets covering RollupRetention.
+// Slots are allocated on first use, so idle minutes cost nothing.
+type routeRollups struct {
+	slots [rollupSlots]*RollupBucket
+}
+
+func rollupSlot(start time.Time) int {
+	return int(start.Unix()/int64(RollupResolution/time.Second)) % rollupSlots
+}
+
+func (rr *routeRollups) record(trace *RequestTrace, weight float64) {
+	if trace.StartTime.IsZero() {
+		return
+	}
+	start := trace.StartTime.Truncate(RollupResolution)
+	i := rollupSlot(start)
+	b := rr.slots[i]
+	switch {
+	case b == nil:
+		b = &RollupBucket{}
+		rr.slots[i] = b
+	case b.Start.After(start):
+		// Older than anything the ring still covers.
+		return
+	case b.Start.Before(start):
+		*b = RollupBucket{}
+	}
+	b.Start = start
+	b.record(trace, weight)
+}
+
+// bucket returns the bucket starting at start, or nil if it has no requests.
+func (rr *routeRollups) bucket(start time.Time) *RollupBucket {
+	b := rr.slots[rollupSlot(start)]
+	if b == nil || !b.Start.Equal(start) {
+		return nil
+	}
+	return b
+}
+
+// rollupRange returns the first and last bucket starts for [since, until),
+// clamped to RollupRetention. since is rounded up, so a window spans as many
+// buckets as it is long, the last of them the one until falls in.
+func rollupRange(since, until time.Time) (first, last time.Time) {
+	last = until.Add(-1).Truncate(RollupResolution)
+	first = since.Add(RollupResolution - 1).Truncate(RollupResolution)
+	if oldest := last.Add(-RollupRetention + RollupResolution); first.Before(oldest) {
+		first = oldest
+	}
+	return first, last
+}
+
+func (rr *routeRollups) window(method, pattern string, since, until time.Time) *RouteWindow {
+	first, last := rollupRange(since, until)
+	w := &RouteWindow{
+		Method:  method,
+		Pattern: pattern,
+		Since:   first,
+		Until:   last.Add(RollupResolution),
+	}
+	for t := first; !t.After(last); t = t.Add(RollupResolution) {
+		if b := rr.bucket(t); b != nil {
+			w.add(b)
+		}
+	}
+	return w
+}
+
+// series returns one bucket per interval in [since, until), with empty
+// intervals included so the result can be charted directly.
+func (rr *routeRollups) series(since, until time.Time) []RollupBucket {
+	first, last := rollupRange(since, until)
+	var out []RollupBucket
+	for t := first; !t.After(last); t = t.Add(RollupResolution) {
+		if b := rr.bucket(t); b != nil {
+			out = append(out, *b)
+		} else {
+			out = append(out, RollupBucket{Start: t})
+		}
+	}
+	return out
+}
+
+// RouteWindow holds a route's metrics over a time window, aggregated from
+// its rollup buckets. Since and Until are rounded to RollupResolution.
+type RouteWindow struct {
+	Method            string
+	Pattern           string
+	Since             time.Time
+	Until             time.Time
+	TotalRequests     int64
+	ErrorCount        int64
+	EstimatedRequests float64
+	TotalLatency      time.Duration
+	DBTime            time.Duration
+	RedisTime         time.Duration
+	ExternalTime      time.Duration
+	Latency           LatencyHistogram
+}
+
+func (w *RouteWindow) add(b *RollupBucket) {
+	w.TotalRequests += b.Requests
+	w.ErrorCount += b.Errors
+	w.EstimatedRequests += b.EstimatedRequests
+	w.TotalLatency += b.TotalLatency
+	w.DBTime += b.DBTime
+	w.RedisTime += b.RedisTime
+	w.ExternalTime += b.ExternalTime
+	w.Latency.Merge(&b.Latency)
+}
+
+func (w *RouteWindow) AvgLatency() time.Duration {
+	if w.TotalRequests == 0 {
+		return 0
+	}
+	return time.Duration(int64(w.TotalLatency) / w.TotalRequests)
+}
+
+func (w *RouteWindow) ErrorRate() float64 {
+	if w.TotalRequests == 0 {
+		return 0
+	}
+	return float64(w.ErrorCount) / float64(w.TotalRequests) * 100
+}
+
+// Throughput returns the average number of requests per minute.
+func (w *RouteWindow) Throughput() float64 {
+	minutes := w.Until.Sub(w.Since).Minutes()
+	if minutes <= 0 {
+		return 0
+	}
+	return float64(w.TotalRequests) / minutes
+}
+
+// Percentile returns the latency at percentile p (0-100), estimated from the
+// window's LatencyHistogram.
+func (w *RouteWindow) Percentile(p float64) time.Duration { return w.Latency.Quantile(p / 100) }
+
+func (w *RouteWindow) P50() time.Duration { return w.Percentile(50) }
+func (w *RouteWindow) P95() time.Duration { return w.Percentile(95) }
+func (w *RouteWindow) P99() time.Duration { return w.Percentile(99) }
+
+func (w *RouteWindow) Status() string {
+	return routeStatus(w.ErrorRate(), w.P95())
+}
@@ -0,0 +1,155 @@
+package xrayhq
+
+import (
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestRouteRollupsWindow(t *testing.T) {
+	rm := NewRouteMetrics("/orders", "GET", 0)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 100; i++ {
+		status := 200
+		if i%10 == 0 {
+			status = 500
+		}
+		rm.Record(&RequestTrace{
+			ResponseStatus: status,
+			Latency:        time.Duration(i+1) * time.Millisecond,
+			TotalDBTime:    time.Millisecond,
+			StartTime:      start.Add(time.Duration(i%5) * time.Minute),
+			SampleRate:     0.5,
+		})
+	}
+
+	w := rm.Window(start, start.Add(5*time.Minute))
+	if w.TotalRequests != 100 || w.ErrorCount != 10 || w.EstimatedRequests != 200 || w.DBTime != 100*time.Millisecond {
+		t.Errorf("unexpected window totals %+v", w)
+	}
+	if w.Throughput() != 20 {
+		t.Errorf("expected 20 requests per minute, got %.1f", w.Throughput())
+	}
+	if p95 := w.P95(); p95 < 80*time.Millisecond || p95 > 115*time.Millisecond {
+		t.Errorf("expected P95 near 95ms, got %v", p95)
+	}
+	if w := rm.Window(start.Add(2*time.Minute), start.Add(3*time.Minute)); w.TotalRequests != 20 {
+		t.Errorf("expected one minute of requests, got %d", w.TotalRequests)
+	}
+
+	series := rm.rollups.series(start.Add(-2*time.Minute), start.Add(7*time.Minute))
+	if len(series) != 9 || series[0].Requests != 0 || series[2].Requests != 20 || !series[8].Start.Equal(start.Add(6*time.Minute)) {
+		t.Errorf("expected a continuous series of 9 minutes, got %d points", len(series))
+	}
+
+	// A day later the same slots are reused; older requests are dropped.
+	later := start.Add(RollupRetention)
+	rm.Record(&RequestTrace{ResponseStatus: 200, StartTime: later})
+	rm.Record(&RequestTrace{ResponseStatus: 200, StartTime: start})
+	if w := rm.Window(later.Add(-RollupRetention), later.Add(time.Minute)); w.TotalRequests != 81 {
+		t.Errorf("expected the oldest minute overwritten, got %d requests", w.TotalRequests)
+	}
+	if w := rm.Window(start, start.Add(time.Minute)); w.TotalRequests != 0 {
+		t.Errorf("expected no requests beyond retention, got %d", w.TotalRequests)
+	}
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	var h LatencyHistogram
+	var values []time.Duration
+	for d := 20 * time.Microsecond; d < 40*time.Second; d = d * 11 / 10 {
+		h.Add(d)
+		values = append(values, d)
+	}
+	for _, q := range []float64{0.01, 0.5, 0.9, 0.99} {
+		exact := values[int(q*float64(len(values)-1))]
+		if got := h.Quantile(q); math.Abs(float64(got-exact))/float64(exact) > 0.2 {
+			t.Errorf("Quantile(%v) = %v, want about %v", q, got, exact)
+		}
+	}
+
+	var merged LatencyHistogram
+	merged.Merge(&h)
+	merged.Merge(&h)
+	if merged.Count() != 2*h.Count() || merged.Quantile(0.5) != h.Quantile(0.5) {
+		t.Errorf("unexpected merge: %d values, median %v", merged.Count(), merged.Quantile(0.5))
+	}
+
+	data, err := json.Marshal(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded LatencyHistogram
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded != h {
+		t.Errorf("expected a JSON round trip, got %v", err)
+	}
+	if err := json.Unmarshal([]byte(`{"counts":[1,2]}`), &decoded); err == nil {
+		t.Error("expected an error for the wrong number of bins")
+	}
+
+	if size := unsafe.Sizeof(RollupBucket{}); size > 320 {
+		t.Errorf("expected a rollup bucket to stay small, got %d bytes", size)
+	}
+}
+
+func TestRouteStatusUsesRecentWindow(t *testing.T) {
+	c, _ := setupTestCollector()
+	now := time.Now()
+	for i := 0; i < 1000; i++ {
+		c.Record(&RequestTrace{Method: "GET", RoutePattern: "/pay", ResponseStatus: 200, StartTime: now.Add(-time.Hour)})
+	}
+	for i := 0; i < 20; i++ {
+		c.Record(&RequestTrace{Method: "GET", RoutePattern: "/pay", ResponseStatus: 503, StartTime: now})
+	}
+
+	rm := c.GetRoute("GET", "/pay")
+	if rm.ErrorRate() > 5 {
+		t.Fatalf("expected a low all-time error rate, got %.1f", rm.ErrorRate())
+	}
+	if rm.Status() != "critical" {
+		t.Errorf("expected recent errors to mark the route critical, got %s", rm.Status())
+	}
+	if w := c.RouteWindow("GET", "/pay", now.Add(-2*time.Hour), now.Add(time.Minute)); w.TotalRequests != 1020 {
+		t.Errorf("expected all requests in a two hour window, got %d", w.TotalRequests)
+	}
+	if c.RouteWindow("GET", "/missing", now, now) != nil {
+		t.Error("expected nil for an unknown route")
+	}
+}
+
+func TestRouteWindowEndpoints(t *testing.T) {
+	c, cfg := setupTestCollector()
+	c.Record(&RequestTrace{ID: "r1", Method: "GET", Path: "/items/1", RoutePattern: "/items/{id}", ResponseStatus: 500, Latency: 10 * time.Millisecond, StartTime: time.Now()})
+	handler := newDashboardHandler(c, cfg)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/route/GET/items/{id}?window=15m", nil))
+	var resp struct {
+		Window    string
+		Requests  int64
+		ErrorRate float64 `json:"error_rate"`
+		Series    []seriesPoint
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Window != "15m" || resp.Requests != 1 || resp.ErrorRate != 100 || len(resp.Series) != 15 {
+		t.Errorf("unexpected route window response %+v", resp)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/route/GET/nope", nil))
+	if rec.Code != 404 {
+		t.Errorf("expected 404 for an unknown route, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/route/GET/items/{id}?window=6h", nil))
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), "Last 6h") {
+		t.Errorf("expected the route page to show the selected window, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,141 @@
+package xrayhq
+
+import (
+	"net/http"
+	"strings"
+)
+
+// PathNormalizer maps a raw request path to a route pattern. It is used for
+// requests that no router matched, so that /users/42 and /users/43 are
+// grouped under the same route.
+type PathNormalizer func(path string) string
+
+// DefaultPathNormalizer replaces numeric IDs, UUIDs and hex hashes in path
+// segments with {id}, {uuid} and {hash} placeholders.
+func DefaultPathNormalizer(path string) string {
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		switch {
+		case seg == "":
+		case isNumeric(seg):
+			segments[i] = "{id}"
+		case isUUID(seg):
+			segments[i] = "{uuid}"
+		case isHexHash(seg):
+			segments[i] = "{hash}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// RouteResolver reports the route pattern a router matched for r, or "" if
+// no route matched.
+type RouteResolver func(r *http.Request) string
+
+// SetRouteResolver registers fn as the route resolver for the trace in r's
+// context. The resolver is called after the handler returns but before the
+// trace is finalized and recorded, which lets adapters for routers that only
+// know the matched route once routing is done (Gin, Echo, Chi) report it.
+func SetRouteResolver(r *http.Request, fn RouteResolver) {
+	if rec := recorderFromContext(r.Context()); rec != nil {
+		rec.mu.Lock()
+		rec.routeResolver = fn
+		rec.mu.Unlock()
+	}
+}
+
+// resolveRoutePattern determines the route pattern for a finished request
+// whose recorder has been sealed.
+// A pattern set explicitly with SetRoutePattern wins, followed by the
+// registered RouteResolver, the net/http ServeMux pattern, and finally the
+// normalized raw path.
+func resolveRoutePattern(rec *traceRecorder, r *http.Request, cfg *Config) string {
+	if rec.trace.RoutePattern != "" {
+		return rec.trace.RoutePattern
+	}
+	if rec.routeResolver != nil {
+		if p := rec.routeResolver(r); p != "" {
+			return p
+		}
+	}
+	if p := muxPattern(r.Pattern); p != "" {
+		return p
+	}
+	return normalizePath(cfg, r.URL.Path)
+}
+
+func normalizePath(cfg *Config, path string) string {
+	if cfg.PathNormalizer != nil {
+		return cfg.PathNormalizer(path)
+	}
+	return path
+}
+
+// muxPattern strips the optional method and host from a ServeMux pattern
+// such as "GET example.com/users/{id}", leaving only the path.
+func muxPattern(pattern string) string {
+	if pattern == "" {
+		return ""
+	}
+	if i := strings.IndexByte(pattern, ' '); i >= 0 {
+		pattern = strings.TrimLeft(pattern[i+1:], " \t")
+	}
+	if i := strings.IndexByte(pattern, '/'); i > 0 {
+		pattern = pattern[i:]
+	}
+	return pattern
+}
+
+func isNumeric(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		switch i {
+		case 8, 13, 18, 23:
+			if s[i] != '-' {
+				return false
+			}
+		default:
+			if !isHexDigit(s[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isHexHash reports whether s looks like a hex digest (MD5, SHA-1, SHA-256,
+// Mongo object IDs and similar). At least one digit is required so that long
+// words spelled only with the letters a-f are left alone.
+func isHexHash(s string) bool {
+	if len(s) < 16 {
+		return false
+	}
+	digits := 0
+	for i := 0; i < len(s); i++ {
+		if !isHexDigit(s[i]) {
+			return false
+		}
+		if s[i] <= '9' {
+			digits++
+		}
+	}
+	return digits > 0
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
@@ -0,0 +1,113 @@
+package xrayhq
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// routeWindows are the windows offered on the route detail page and
+// accepted by the route API, shortest first.
+var routeWindows = []struct {
+	Label    string
+	Duration time.Duration
+}{
+	{"15m", 15 * time.Minute},
+	{"1h", time.Hour},
+	{"6h", 6 * time.Hour},
+	{"24h", RollupRetention},
+}
+
+// parseRouteWindow looks up a window label, defaulting to one hour.
+func parseRouteWindow(label string) (string, time.Duration) {
+	for _, w := range routeWindows {
+		if w.Label == label {
+			return w.Label, w.Duration
+		}
+	}
+	return "1h", time.Hour
+}
+
+// routePath returns the "METHOD/pattern" form used in route URLs.
+func routePath(method, pattern string) string {
+	return method + "/" + strings.TrimPrefix(pattern, "/")
+}
+
+// parseRoutePath is the inverse of routePath.
+func parseRoutePath(path string) (method, pattern string, ok bool) {
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) < 2 || parts[0] == "" {
+		return "", "", false
+	}
+	if parts[1] == OverflowRoute {
+		return parts[0], OverflowRoute, true
+	}
+	return parts[0], "/" + parts[1], true
+}
+
+type seriesPoint struct {
+	Start     time.Time `json:"start"`
+	Requests  int64     `json:"requests"`
+	Errors    int64     `json:"errors"`
+	ErrorRate float64   `json:"error_rate"`
+	P95       float64   `json:"p95_ms"`
+}
+
+func seriesPoints(buckets []RollupBucket) []seriesPoint {
+	points := make([]seriesPoint, len(buckets))
+	for i, b := range buckets {
+		points[i] = seriesPoint{
+			Start:     b.Start,
+			Requests:  b.Requests,
+			Errors:    b.Errors,
+			ErrorRate: b.ErrorRate(),
+			P95:       durationMillis(b.P95()),
+		}
+	}
+	return points
+}
+
+func durationMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// handleAPIRoute serves a route's metrics over a window as JSON, with one
+// series point per minute: /api/route/METHOD/pattern?window=1h.
+func (ds *DashboardServer) handleAPIRoute(w http.ResponseWriter, r *http.Request) {
+	method, pattern, ok := parseRoutePath(strings.TrimPrefix(r.URL.Path, "/api/route/"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	label, window := parseRouteWindow(r.URL.Query().Get("window"))
+	until := ds.collector.now()
+	since := until.Add(-window)
+	rw := ds.collector.RouteWindow(method, pattern, since, until)
+	if rw == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"method":      method,
+		"pattern":     pattern,
+		"window":      label,
+		"since":       rw.Since,
+		"until":       rw.Until,
+		"requests":    rw.TotalRequests,
+		"errors":      rw.ErrorCount,
+		"error_rate":  rw.ErrorRate(),
+		"throughput":  rw.Throughput(),
+		"p50_ms":      durationMillis(rw.P50()),
+		"p95_ms":      durationMillis(rw.P95()),
+		"p99_ms":      durationMillis(rw.P99()),
+		"db_ms":       durationMillis(rw.DBTime),
+		"redis_ms":    durationMillis(rw.RedisTime),
+		"external_ms": durationMillis(rw.ExternalTime),
+		"status":      rw.Status(),
+		"latency":     rw.Latency,
+		"series":      seriesPoints(ds.collector.RouteSeries(method, pattern, since, until)),
+	})
+}
@@ -0,0 +1,100 @@
+package xrayhq
+
+import (
+	"net/http"
+	"time"
+)
+
+// OverflowRoute is the route pattern requests are counted under once
+// Config.MaxRoutes distinct routes have metrics.
+const OverflowRoute = "<other>"
+
+// overflowMethods are the methods that keep their own overflow route; any
+// other method is folded into "OTHER <other>" so method names can't grow
+// the route table either.
+var overflowMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true,
+	http.MethodPut: true, http.MethodPatch: true, http.MethodDelete: true,
+	http.MethodConnect: true, http.MethodOptions: true, http.MethodTrace: true,
+}
+
+// routeFor returns the metrics for trace's route, creating them if there is
+// room. When there isn't, the trace's route (and a non-standard method) is
+// rewritten to the overflow route, so metrics, the store, alerts and
+// sampling all see the same bounded set of routes. c.mu must be held.
+func (c *Collector) routeFor(trace *RequestTrace) *RouteMetrics {
+	now := c.now()
+	key := trace.Method + " " + trace.RoutePattern
+	if rm, ok := c.routes[key]; ok {
+		c.touchRoute(rm, now)
+		return rm
+	}
+
+	if max := c.config.MaxRoutes; max > 0 && len(c.routes) >= max && !c.evictIdleRoute(now) {
+		c.collapsedRoutes.Add(1)
+		if !overflowMethods[trace.Method] {
+			trace.Method = "OTHER"
+		}
+		trace.RoutePattern = OverflowRoute
+		key = trace.Method + " " + OverflowRoute
+		if rm, ok := c.routes[key]; ok {
+			c.touchRoute(rm, now)
+			return rm
+		}
+	}
+
+	// Overflow routes are created even past MaxRoutes; there are at most
+	// one per standard method plus OTHER.
+	rm := newRouteMetrics(trace.RoutePattern, trace.Method)
+	rm.lastSeen = now
+	rm.lru = c.routeLRU.PushFront(rm)
+	c.routes[key] = rm
+	return rm
+}
+
+func (c *Collector) touchRoute(rm *RouteMetrics, now time.Time) {
+	rm.lastSeen = now
+	c.routeLRU.MoveToFront(rm.lru)
+}
+
+// evictIdleRoute removes the least recently used route if it has been idle
+// for at least RouteIdleTTL. c.mu must be held.
+func (c *Collector) evictIdleRoute(now time.Time) bool {
+	ttl := c.config.RouteIdleTTL
+	back := c.routeLRU.Back()
+	if ttl <= 0 || back == nil {
+		return false
+	}
+	rm := back.Value.(*RouteMetrics)
+	if now.Sub(rm.lastSeen) < ttl {
+		return false
+	}
+	key := rm.Method + " " + rm.Pattern
+	c.routeLRU.Remove(back)
+	delete(c.routes, key)
+	c.evictedRoutes.Add(1)
+	if c.adaptive != nil {
+		c.adaptive.forget(key)
+	}
+	return true
+}
+
+// RouteCount returns how many routes have metrics, including overflow
+// routes.
+func (c *Collector) RouteCount() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.routes)
+}
+
+// CollapsedRoutes returns how many requests were counted under
+// OverflowRoute because MaxRoutes was reached.
+func (c *Collector) CollapsedRoutes() int64 {
+	return c.collapsedRoutes.Load()
+}
+
+// EvictedRoutes returns how many idle routes were evicted to make room for
+// new ones.
+func (c *Collector) EvictedRoutes() int64 {
+	return c.evictedRoutes.Load()
+}
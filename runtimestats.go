@@ -0,0 +1,68 @@
+package xrayhq
+
+import "runtime/metrics"
+
+// runtimeStats is a reading of the process-wide runtime counters a trace
+// records. Unlike runtime.ReadMemStats, reading them does not stop the world.
+type runtimeStats struct {
+	heapAllocs uint64 // cumulative bytes allocated on the heap
+	goroutines int
+}
+
+func readRuntimeStats() runtimeStats {
+	samples := [2]metrics.Sample{
+		{Name: "/gc/heap/allocs:bytes"},
+		{Name: "/sched/goroutines:goroutines"},
+	}
+	metrics.Read(samples[:])
+
+	var s runtimeStats
+	if samples[0].Value.Kind() == metrics.KindUint64 {
+		s.heapAllocs = samples[0].Value.Uint64()
+	}
+	if samples[1].Value.Kind() == metrics.KindUint64 {
+		s.goroutines = int(samples[1].Value.Uint64())
+	}
+	return s
+}
+
+// requestStats tracks one traced request's share of the process: how many
+// requests ran alongside it and, when sampled, the runtime counters around it.
+type requestStats struct {
+	collector *Collector
+	inFlight  int64  // requests in flight when this one started, itself included
+	started   uint64 // requests started before and including this one
+	sampled   bool
+	before    runtimeStats
+}
+
+// beginRequest marks a traced request as in flight and takes the opening
+// runtime reading if this request is sampled.
+func (c *Collector) beginRequest() *requestStats {
+	rs := &requestStats{collector: c, started: c.requestsStarted.Add(1), inFlight: c.inFlight.Add(1)}
+	if every := c.config.RuntimeSampling; every > 0 {
+		rs.sampled = (rs.started-1)%uint64(every) == 0
+	}
+	if rs.sampled {
+		rs.before = readRuntimeStats()
+	}
+	return rs
+}
+
+// end takes the closing reading, stores both on trace and marks the request
+// as finished. The requests overlapping this one are those already in flight
+// when it started plus those started while it ran.
+func (rs *requestStats) end(trace *RequestTrace) {
+	c := rs.collector
+	c.inFlight.Add(-1)
+	trace.ConcurrentRequests = int(rs.inFlight + int64(c.requestsStarted.Load()-rs.started))
+	if !rs.sampled {
+		return
+	}
+	after := readRuntimeStats()
+	trace.RuntimeSampled = true
+	trace.GoroutinesBefore = rs.before.goroutines
+	trace.GoroutinesAfter = after.goroutines
+	trace.MemAllocBefore = rs.before.heapAllocs
+	trace.MemAllocAfter = after.heapAllocs
+}
@@ -0,0 +1,288 @@
+package xrayhq
+
+import (
+	"math/rand/v2"
+	"strings"
+)
+
+// SamplingMode selects when the keep-or-drop decision for a request is made.
+type SamplingMode string
+
+const (
+	// SamplingHead decides before the request runs. Unsampled requests are
+	// not traced at all and do not count towards route metrics.
+	SamplingHead SamplingMode = "head"
+	// SamplingTail traces every request and decides once it has finished.
+	// Errors, panics, requests that raised an alert and slow requests are
+	// always kept; the rest are kept at SamplingRate. Route metrics and
+	// alerts see every request.
+	SamplingTail SamplingMode = "tail"
+)
+
+// Reasons a trace was kept, recorded in RequestTrace.SampleReason.
+const (
+	SampleReasonSampled = "sampled"
+	SampleReasonError   = "error"
+	SampleReasonPanic   = "panic"
+	SampleReasonAlert   = "alert"
+	SampleReasonSlow    = "slow"
+	SampleReasonRule    = "rule"
+)
+
+// sampleHit reports whether an event kept at rate should be kept this time.
+func sampleHit(rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+	return rand.Float64() < rate
+}
+
+// headSampled reports whether a request should be traced at all. In tail
+// mode every request is traced and the decision is left to tailSampleReason.
+func headSampled(cfg *Config) bool {
+	if cfg.SamplingMode == SamplingTail {
+		return true
+	}
+	return sampleHit(cfg.SamplingRate)
+}
+
+// tailSampleReason decides whether a finished trace is kept in tail mode
+// and why. It returns "" for traces that are dropped, and the probability
+// with which a kept trace was sampled: 1 for traces kept regardless of the
+// rate. It must run after the alert engine so alerts raised by the trace are
+// taken into account.
+func tailSampleReason(cfg *Config, t *RequestTrace) (reason string, rate float64) {
+	switch {
+	case t.SampleReason == SampleReasonRule:
+		return SampleReasonRule, 1
+	case t.Panicked:
+		return SampleReasonPanic, 1
+	case t.ResponseStatus >= 500:
+		return SampleReasonError, 1
+	case len(t.Alerts) > 0:
+		return SampleReasonAlert, 1
+	case cfg.TailSlowThreshold > 0 && t.Latency >= cfg.TailSlowThreshold:
+		return SampleReasonSlow, 1
+	}
+	rate = cfg.SamplingRate
+	if t.ruleRateSet {
+		rate = t.ruleRate
+	}
+	if sampleHit(rate) {
+		return SampleReasonSampled, rate
+	}
+	return "", rate
+}
+
+// RuleAction is what a SamplingRule does with the requests it matches.
+type RuleAction string
+
+const (
+	// RuleDrop neither traces nor counts the request.
+	RuleDrop RuleAction = "drop"
+	// RuleSample keeps the request at the rule's Rate instead of
+	// SamplingRate. In tail mode errors, panics, alerts and slow requests
+	// are still always kept.
+	RuleSample RuleAction = "sample"
+	// RuleCapture always keeps the request, capturing bodies and headers
+	// as set on the rule rather than by CaptureBody and CaptureHeaders.
+	RuleCapture RuleAction = "capture"
+)
+
+// SamplingRule overrides sampling for matching requests. Rules are tried in
+// order and the first match wins; empty conditions match anything.
+//
+// Path and Route are globs where "*" matches within one path segment and
+// "**" matches any number of segments. Route rules are evaluated when the
+// request finishes, since routers only report the matched pattern then;
+// until that point the request is traced with the configured capture
+// settings.
+type SamplingRule struct {
+	Method string // e.g. "GET"; empty matches any method
+	Path   string // glob on the raw request path, e.g. "/static/**"
+	Route  string // glob on the route pattern, e.g. "/admin/*"
+	Header string // "Name" to require a header, or "Name=value"
+
+	Action RuleAction
+	Rate   float64 // for RuleSample
+
+	// CaptureBody and CaptureHeaders apply to RuleCapture.
+	CaptureBody    bool
+	CaptureHeaders bool
+}
+
+// requestHeader looks up a request header by name.
+type requestHeader func(name string) string
+
+func (rule *SamplingRule) matchesRequest(method, path string, header requestHeader) bool {
+	if rule.Method != "" && !strings.EqualFold(rule.Method, method) {
+		return false
+	}
+	if rule.Path != "" && !globMatch(rule.Path, path) {
+		return false
+	}
+	if rule.Header != "" {
+		name, want, hasValue := strings.Cut(rule.Header, "=")
+		got := header(strings.TrimSpace(name))
+		if got == "" || (hasValue && got != strings.TrimSpace(want)) {
+			return false
+		}
+	}
+	return true
+}
+
+// globMatch matches s against a glob where "*" matches within one path
+// segment and "**" matches across segments.
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch {
+		case strings.HasPrefix(pattern, "**"):
+			rest := strings.TrimLeft(pattern, "*")
+			for i := 0; i <= len(s); i++ {
+				if globMatch(rest, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case pattern[0] == '*':
+			rest := pattern[1:]
+			for i := 0; i <= len(s); i++ {
+				if globMatch(rest, s[i:]) {
+					return true
+				}
+				if i < len(s) && s[i] == '/' {
+					break
+				}
+			}
+			return false
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+// samplePlan is the sampling decision for one request, made in two steps:
+// planSampling before the handler runs and finish once it has returned.
+type samplePlan struct {
+	cfg            *Config
+	trace          bool // whether to trace the request at all
+	captureBody    bool
+	captureHeaders bool
+	rule           *SamplingRule // the matching rule, if it is already known
+	deferred       int           // first rule needing the route pattern, or -1
+}
+
+// planSampling applies the rules that can be decided from the request alone.
+func planSampling(cfg *Config, method, path string, header requestHeader) *samplePlan {
+	p := &samplePlan{
+		cfg:            cfg,
+		captureBody:    cfg.CaptureBody,
+		captureHeaders: cfg.CaptureHeaders,
+		deferred:       -1,
+	}
+	for i := range cfg.SamplingRules {
+		rule := &cfg.SamplingRules[i]
+		if !rule.matchesRequest(method, path, header) {
+			continue
+		}
+		if rule.Route != "" {
+			p.deferred = i
+			p.trace = true
+			return p
+		}
+		p.rule = rule
+		break
+	}
+
+	switch {
+	case p.rule == nil:
+		p.trace = headSampled(cfg)
+	case p.rule.Action == RuleDrop:
+		p.trace = false
+	case p.rule.Action == RuleCapture:
+		p.trace = true
+		p.captureBody = p.rule.CaptureBody
+		p.captureHeaders = p.rule.CaptureHeaders
+	case cfg.SamplingMode == SamplingTail:
+		p.trace = true
+	default:
+		p.trace = sampleHit(p.rule.Rate)
+	}
+	return p
+}
+
+// sampled reports whether the request will be kept, as far as that is known
+// before it runs. Only head sampling without a pending route rule, and
+// capture rules, decide up front; tail sampling decides once the request
+// has finished, so its requests are reported as not sampled. Adaptive
+// sampling may still drop a request reported as sampled.
+func (p *samplePlan) sampled() bool {
+	if !p.trace || p.deferred >= 0 {
+		return false
+	}
+	if p.rule != nil && p.rule.Action == RuleCapture {
+		return true
+	}
+	return p.cfg.SamplingMode != SamplingTail
+}
+
+// finish resolves rules that needed the route pattern and applies the
+// matching rule to the finished trace. It reports whether the trace should
+// be passed to the collector, and sets the trace's SampleRate to the
+// probability with which it got this far.
+func (p *samplePlan) finish(t *RequestTrace, header requestHeader) bool {
+	tail := p.cfg.SamplingMode == SamplingTail
+	if p.deferred >= 0 {
+		for i := p.deferred; i < len(p.cfg.SamplingRules); i++ {
+			rule := &p.cfg.SamplingRules[i]
+			if rule.matchesRequest(t.Method, t.Path, header) && (rule.Route == "" || globMatch(rule.Route, t.RoutePattern)) {
+				p.rule = rule
+				break
+			}
+		}
+		if p.rule == nil && !tail && !sampleHit(p.cfg.SamplingRate) {
+			return false
+		}
+	}
+
+	t.SampleRate = 1
+	if p.rule == nil {
+		if !tail {
+			t.SampleRate = p.cfg.SamplingRate
+		}
+		return true
+	}
+
+	switch p.rule.Action {
+	case RuleDrop:
+		return false
+	case RuleCapture:
+		if !p.rule.CaptureBody {
+			t.RequestBody, t.ResponseBody = nil, nil
+			t.RequestBodyTruncated, t.ResponseBodyTruncated = false, false
+		}
+		if !p.rule.CaptureHeaders {
+			t.RequestHeaders, t.ResponseHeaders = map[string]string{}, nil
+		}
+		t.SampleReason = SampleReasonRule
+		return true
+	default:
+		if tail {
+			t.ruleRate = p.rule.Rate
+			t.ruleRateSet = true
+			return true
+		}
+		if p.deferred >= 0 && !sampleHit(p.rule.Rate) {
+			return false
+		}
+		t.SampleRate = p.rule.Rate
+		return true
+	}
+}
@@ -0,0 +1,212 @@
+package xrayhq
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGlobMatch(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"/healthz", "/healthz", true},
+		{"/healthz", "/healthz/deep", false},
+		{"/static/*", "/static/app.js", true},
+		{"/static/*", "/static/js/app.js", false},
+		{"/static/**", "/static/js/app.js", true},
+		{"/static/**", "/static/", true},
+		{"/api/*/orders", "/api/v1/orders", true},
+		{"/api/*/orders", "/api/v1/v2/orders", false},
+		{"**.png", "/img/logo.png", true},
+		{"/users/{id}", "/users/{id}", true},
+	}
+	for _, c := range cases {
+		if got := globMatch(c.pattern, c.s); got != c.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", c.pattern, c.s, got, c.want)
+		}
+	}
+}
+
+func TestSamplingRules(t *testing.T) {
+	c, cfg := setupTestCollector()
+	cfg.SamplingRules = []SamplingRule{
+		{Path: "/healthz", Action: RuleDrop},
+		{Header: "X-Debug=1", Action: RuleCapture, CaptureBody: true, CaptureHeaders: true},
+		{Method: "POST", Path: "/upload/**", Action: RuleCapture},
+		{Route: "/static/**", Action: RuleDrop},
+		{Path: "/api/**", Action: RuleSample, Rate: 0},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/static/", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	handler := coreMiddleware(c, cfg, mux)
+
+	serve := func(method, path, body string, headers ...string) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	serve("GET", "/healthz", "")
+	serve("GET", "/static/css/app.css", "")
+	serve("GET", "/api/orders", "")
+	if c.RequestCount() != 0 || len(c.GetRoutes()) != 0 {
+		t.Fatalf("expected dropped and unsampled requests to leave no trace, got %d", c.RequestCount())
+	}
+
+	serve("GET", "/api/orders", "", "X-Debug", "1")
+	if c.RequestCount() != 1 {
+		t.Fatal("expected header rule to win over the later sample rule")
+	}
+	if tr := c.GetRecentRequests(1)[0]; tr.SampleReason != SampleReasonRule || len(tr.RequestHeaders) == 0 {
+		t.Errorf("expected captured trace with headers, got reason %q", tr.SampleReason)
+	}
+
+	serve("POST", "/upload/avatar", "secret-bytes", "Content-Type", "text/plain")
+	tr := c.GetRecentRequests(1)[0]
+	if tr.Path != "/upload/avatar" {
+		t.Fatalf("expected upload to be captured, got %s", tr.Path)
+	}
+	if tr.RequestBody != nil || tr.ResponseBody != nil || len(tr.RequestHeaders) != 0 {
+		t.Error("expected capture rule without bodies and headers to skip them")
+	}
+
+	serve("GET", "/about", "")
+	if c.GetRecentRequests(1)[0].Path != "/about" {
+		t.Error("expected requests matching no rule to follow the default sampling")
+	}
+}
+
+func TestSamplingRuleRateInTailMode(t *testing.T) {
+	c, cfg := setupTestCollector()
+	cfg.SamplingMode = SamplingTail
+	cfg.SamplingRules = []SamplingRule{{Path: "/api/**", Action: RuleSample, Rate: 0}}
+
+	handler := coreMiddleware(c, cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/ok", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/fail", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/other", nil))
+
+	if len(c.GetRoutes()) != 3 {
+		t.Errorf("expected all routes counted in tail mode, got %d", len(c.GetRoutes()))
+	}
+	got := map[string]bool{}
+	for _, tr := range c.GetAllRequests() {
+		got[tr.Path] = true
+	}
+	if got["/api/ok"] || !got["/api/fail"] || !got["/other"] {
+		t.Errorf("expected rule rate for /api and the default rate elsewhere, got %v", got)
+	}
+}
+
+func TestValidateSamplingRules(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.SamplingRules = []SamplingRule{{Path: "/x", Action: "keep"}}
+	if cfg.Validate() == nil {
+		t.Error("expected unknown action to be rejected")
+	}
+	cfg.SamplingRules = []SamplingRule{{Path: "/x", Action: RuleSample, Rate: 2}}
+	if cfg.Validate() == nil {
+		t.Error("expected out of range rate to be rejected")
+	}
+}
+
+func TestAdaptiveSampler(t *testing.T) {
+	s := newAdaptiveSampler(2, time.Second)
+	now := time.Unix(0, 0)
+	s.now = func() time.Time { return now }
+
+	kept := 0
+	for i := 0; i < 100; i++ {
+		if keep, _ := s.sample("GET /hot"); keep {
+			kept++
+		}
+	}
+	if kept < 2 || kept > 12 {
+		t.Errorf("expected about the budget kept in the first interval, got %d", kept)
+	}
+
+	now = now.Add(time.Second)
+	if keep, rate := s.sample("GET /hot"); !keep || rate != 1 {
+		t.Errorf("expected the first request of an interval kept at rate 1, got %v at %v", keep, rate)
+	}
+	if _, rate := s.sample("GET /hot"); rate != 0.02 {
+		t.Errorf("expected rate derived from the previous interval, got %v", rate)
+	}
+
+	if keep, rate := s.sample("GET /quiet"); !keep || rate != 1 {
+		t.Errorf("expected a quiet route to keep everything, got %v at %v", keep, rate)
+	}
+
+	now = now.Add(5 * time.Second)
+	s.sample("GET /hot")
+	if _, rate := s.sample("GET /hot"); rate != 1 {
+		t.Errorf("expected an idle route to reset its rate, got %v", rate)
+	}
+}
+
+func TestAdaptiveSamplerUnbiased(t *testing.T) {
+	const trials, budget, steady, surge = 200, 20, 100, 400
+	var estimate, kept float64
+	for i := 0; i < trials; i++ {
+		s := newAdaptiveSampler(budget, time.Second)
+		now := time.Unix(0, 0)
+		s.now = func() time.Time { return now }
+		for j := 0; j < steady; j++ {
+			s.sample("GET /hot")
+		}
+		now = now.Add(time.Second)
+		for j := 0; j < surge; j++ {
+			if keep, rate := s.sample("GET /hot"); keep {
+				estimate += 1 / rate
+				kept++
+			}
+		}
+	}
+	if got := estimate / trials; math.Abs(got-surge)/surge > 0.1 {
+		t.Errorf("expected weighted kept traces to estimate %d requests, got %.0f", surge, got)
+	}
+	if got := kept / trials; got > 2*budget {
+		t.Errorf("expected a surge to keep at most twice the budget, kept %.1f", got)
+	}
+}
+
+func TestAdaptiveSamplingExtrapolation(t *testing.T) {
+	c, cfg := setupTestCollector()
+	cfg.MaxTracesPerSecond = 1
+	cfg.SamplingRate = 0.5
+	c = NewCollector(cfg)
+
+	for i := 0; i < 10; i++ {
+		c.Record(&RequestTrace{ID: fmt.Sprint(i), Method: "GET", RoutePattern: "/hot", ResponseStatus: 200, SampleRate: 0.5})
+	}
+	c.Record(&RequestTrace{ID: "err", Method: "GET", RoutePattern: "/hot", ResponseStatus: 500, SampleRate: 0.5})
+
+	rm := c.GetRoute("GET", "/hot")
+	if rm.EstimatedRequests != 22 || rm.EstimatedErrors != 2 || !rm.Extrapolated() {
+		t.Errorf("expected counts weighted by sample rate, got %v requests and %v errors", rm.EstimatedRequests, rm.EstimatedErrors)
+	}
+	if c.SampledOut() == 0 {
+		t.Error("expected the adaptive cap to drop traces")
+	}
+	for _, tr := range c.GetAllRequests() {
+		if tr.SampleRate <= 0 || tr.SampleRate > 0.5 {
+			t.Errorf("expected effective rate recorded on trace %s, got %v", tr.ID, tr.SampleRate)
+		}
+	}
+}
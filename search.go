@@ -0,0 +1,73 @@
+package xrayhq
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+const (
+	searchPageSize = 50
+	searchMaxLimit = 200
+)
+
+// handleSearch renders the search page, one page of searchPageSize results
+// at a time.
+func (ds *DashboardServer) handleSearch(w http.ResponseWriter, r *http.Request) {
+	raw := r.URL.Query().Get("q")
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	data := map[string]interface{}{
+		"Query":   raw,
+		"PageNum": page,
+		"Page":    "search",
+	}
+	if raw != "" {
+		q, err := parseQueryAt(raw, ds.collector.now())
+		if err != nil {
+			data["Error"] = err.Error()
+		} else {
+			results, more := ds.collector.Search(q, (page-1)*searchPageSize, searchPageSize)
+			data["Results"] = results
+			data["More"] = more
+		}
+	}
+	ds.render(w, "search.html", data)
+}
+
+// handleAPISearch serves search results as JSON. It takes the query in q and
+// pages with offset and limit.
+func (ds *DashboardServer) handleAPISearch(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	offset, _ := strconv.Atoi(params.Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+	limit, err := strconv.Atoi(params.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = searchPageSize
+	}
+	if limit > searchMaxLimit {
+		limit = searchMaxLimit
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	q, err := parseQueryAt(params.Get("q"), ds.collector.now())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	results, more := ds.collector.Search(q, offset, limit)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"query":   q.String(),
+		"offset":  offset,
+		"limit":   limit,
+		"more":    more,
+		"results": results,
+	})
+}
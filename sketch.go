@@ -0,0 +1,243 @@
+package xrayhq
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// DefaultSketchAccuracy is the relative accuracy of route latency
+	// percentiles.
+	DefaultSketchAccuracy = 0.01
+
+	// sketchMaxBins bounds a sketch's memory. At 1% accuracy it spans
+	// nanoseconds to days before the lowest bins have to be merged.
+	sketchMaxBins = 2048
+)
+
+// LatencySketch is a DDSketch over latencies: a streaming quantile summary
+// whose quantiles are within a fixed relative error of the exact value,
+// using bounded memory no matter how many values it has seen. Sketches with
+// the same accuracy can be merged, for example across processes. A
+// LatencySketch is not safe for concurrent use.
+type LatencySketch struct {
+	accuracy float64
+	gamma    float64
+	logGamma float64
+
+	offset int // index of bins[0]
+	bins   []uint64
+	zero   uint64 // values <= 0
+	count  uint64
+}
+
+// NewLatencySketch returns an empty sketch whose quantiles are within
+// relativeAccuracy (for example 0.01 for 1%) of the exact value.
+func NewLatencySketch(relativeAccuracy float64) *LatencySketch {
+	if relativeAccuracy <= 0 || relativeAccuracy >= 1 {
+		relativeAccuracy = DefaultSketchAccuracy
+	}
+	gamma := (1 + relativeAccuracy) / (1 - relativeAccuracy)
+	return &LatencySketch{
+		accuracy: relativeAccuracy,
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+	}
+}
+
+// RelativeAccuracy returns the sketch's relative error bound.
+func (s *LatencySketch) RelativeAccuracy() float64 { return s.accuracy }
+
+// Count returns the number of values added.
+func (s *LatencySketch) Count() uint64 {
+	if s == nil {
+		return 0
+	}
+	return s.count
+}
+
+func (s *LatencySketch) index(d time.Duration) int {
+	return int(math.Ceil(math.Log(float64(d)) / s.logGamma))
+}
+
+// value returns the estimate for bin index i, which is within the sketch's
+// accuracy of every value in the bin.
+func (s *LatencySketch) value(i int) time.Duration {
+	return time.Duration(2 * math.Pow(s.gamma, float64(i)) / (s.gamma + 1))
+}
+
+// Add records one latency.
+func (s *LatencySketch) Add(d time.Duration) {
+	s.count++
+	if d <= 0 {
+		s.zero++
+		return
+	}
+	// bin may reallocate s.bins, so it must run before s.bins is indexed.
+	i := s.bin(s.index(d))
+	s.bins[i]++
+}
+
+// bin returns the position of index i in bins, growing the range as
+// needed. Past sketchMaxBins the lowest bins are merged, which only loses
+// accuracy for the fastest values.
+func (s *LatencySketch) bin(i int) int {
+	if len(s.bins) == 0 {
+		s.offset = i
+		s.bins = append(s.bins, 0)
+		return 0
+	}
+	if i < s.offset {
+		if lowest := s.offset + len(s.bins) - sketchMaxBins; i < lowest {
+			i = lowest
+		}
+		if n := s.offset - i; n > 0 {
+			s.bins = append(make([]uint64, n, n+len(s.bins)), s.bins...)
+			s.offset = i
+		}
+		return i - s.offset
+	}
+	if end := s.offset + len(s.bins); i >= end {
+		s.bins = append(s.bins, make([]uint64, i-end+1)...)
+		if excess := len(s.bins) - sketchMaxBins; excess > 0 {
+			for _, n := range s.bins[:excess] {
+				s.bins[excess] += n
+			}
+			s.bins = append([]uint64(nil), s.bins[excess:]...)
+			s.offset += excess
+		}
+	}
+	return i - s.offset
+}
+
+// Merge adds other's values to s. Both sketches must have the same
+// accuracy.
+func (s *LatencySketch) Merge(other *LatencySketch) error {
+	if other == nil || other.count == 0 {
+		return nil
+	}
+	if other.accuracy != s.accuracy {
+		return fmt.Errorf("xrayhq: cannot merge sketches with accuracy %v and %v", s.accuracy, other.accuracy)
+	}
+	s.count += other.count
+	s.zero += other.zero
+	for j, n := range other.bins {
+		if n != 0 {
+			i := s.bin(other.offset + j)
+			s.bins[i] += n
+		}
+	}
+	return nil
+}
+
+// Copy returns an independent copy of the sketch.
+func (s *LatencySketch) Copy() *LatencySketch {
+	if s == nil {
+		return nil
+	}
+	c := *s
+	c.bins = append([]uint64(nil), s.bins...)
+	return &c
+}
+
+// Quantile returns the latency at quantile q, between 0 and 1. It returns 0
+// for an empty sketch.
+func (s *LatencySketch) Quantile(q float64) time.Duration {
+	if s == nil || s.count == 0 {
+		return 0
+	}
+	rank := q * float64(s.count-1)
+	seen := s.zero
+	if float64(seen) > rank {
+		return 0
+	}
+	for j, n := range s.bins {
+		seen += n
+		if float64(seen) > rank {
+			return s.value(s.offset + j)
+		}
+	}
+	return s.value(s.offset + len(s.bins) - 1)
+}
+
+// CountBelow estimates how many values were less than d.
+func (s *LatencySketch) CountBelow(d time.Duration) uint64 {
+	if s == nil || s.count == 0 {
+		return 0
+	}
+	if d <= 0 {
+		return 0
+	}
+	n := s.zero
+	limit := s.index(d)
+	for j, c := range s.bins {
+		if s.offset+j >= limit {
+			break
+		}
+		n += c
+	}
+	return n
+}
+
+// CountAtOrBelow estimates how many values were less than or equal to d.
+// Every such value is counted; so may values in d's bin that are above d,
+// which are within the sketch's relative accuracy of d.
+func (s *LatencySketch) CountAtOrBelow(d time.Duration) uint64 {
+	if s == nil || s.count == 0 {
+		return 0
+	}
+	if d <= 0 {
+		return s.zero
+	}
+	n := s.zero
+	limit := s.index(d)
+	for j, c := range s.bins {
+		if s.offset+j > limit {
+			break
+		}
+		n += c
+	}
+	return n
+}
+
+type latencySketchJSON struct {
+	RelativeAccuracy float64  `json:"relative_accuracy"`
+	Offset           int      `json:"offset"`
+	Bins             []uint64 `json:"bins"`
+	Zero             uint64   `json:"zero,omitempty"`
+}
+
+// MarshalJSON encodes the sketch so it can be exported and merged
+// elsewhere.
+func (s *LatencySketch) MarshalJSON() ([]byte, error) {
+	return json.Marshal(latencySketchJSON{
+		RelativeAccuracy: s.accuracy,
+		Offset:           s.offset,
+		Bins:             s.bins,
+		Zero:             s.zero,
+	})
+}
+
+func (s *LatencySketch) UnmarshalJSON(data []byte) error {
+	var v latencySketchJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.RelativeAccuracy <= 0 || v.RelativeAccuracy >= 1 {
+		return fmt.Errorf("xrayhq: invalid sketch accuracy %v", v.RelativeAccuracy)
+	}
+	if len(v.Bins) > sketchMaxBins {
+		return fmt.Errorf("xrayhq: sketch has %d bins, more than %d", len(v.Bins), sketchMaxBins)
+	}
+	*s = *NewLatencySketch(v.RelativeAccuracy)
+	s.offset = v.Offset
+	s.bins = v.Bins
+	s.zero = v.Zero
+	s.count = v.Zero
+	for _, n := range v.Bins {
+		s.count += n
+	}
+	return nil
+}
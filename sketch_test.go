@@ -0,0 +1,179 @@
+package xrayhq
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func exactQuantile(sorted []time.Duration, q float64) time.Duration {
+	return sorted[int(q*float64(len(sorted)-1))]
+}
+
+func TestLatencySketchAccuracy(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	s := NewLatencySketch(DefaultSketchAccuracy)
+	var values []time.Duration
+	for i := 0; i < 50000; i++ {
+		// Log-normal around a few milliseconds with a long tail.
+		d := time.Duration(math.Exp(rng.NormFloat64()*1.5) * float64(3*time.Millisecond))
+		s.Add(d)
+		values = append(values, d)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	for _, q := range []float64{0, 0.5, 0.9, 0.95, 0.99, 0.999, 1} {
+		want := exactQuantile(values, q)
+		got := s.Quantile(q)
+		if err := math.Abs(float64(got-want)) / float64(want); err > DefaultSketchAccuracy {
+			t.Errorf("q=%v: got %v, want %v (error %.3f)", q, got, want, err)
+		}
+	}
+	if s.Count() != 50000 || len(s.bins) > sketchMaxBins {
+		t.Errorf("unexpected count %d or %d bins", s.Count(), len(s.bins))
+	}
+	if below := s.CountBelow(exactQuantile(values, 0.5)); below < 24000 || below > 26000 {
+		t.Errorf("expected about half the values below the median, got %d", below)
+	}
+}
+
+func TestLatencySketchCountAtOrBelow(t *testing.T) {
+	s := NewLatencySketch(DefaultSketchAccuracy)
+	for _, d := range []time.Duration{0, 50 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond} {
+		s.Add(d)
+	}
+	if n := s.CountAtOrBelow(100 * time.Millisecond); n != 4 {
+		t.Errorf("expected 4 values at or below 100ms, got %d", n)
+	}
+	if n := s.CountBelow(100 * time.Millisecond); n != 2 {
+		t.Errorf("expected 2 values below 100ms, got %d", n)
+	}
+	if n := s.CountAtOrBelow(0); n != 1 {
+		t.Errorf("expected the zero value at or below 0, got %d", n)
+	}
+}
+
+func TestLatencySketchMerge(t *testing.T) {
+	a := NewLatencySketch(DefaultSketchAccuracy)
+	b := NewLatencySketch(DefaultSketchAccuracy)
+	all := NewLatencySketch(DefaultSketchAccuracy)
+	for i := 1; i <= 1000; i++ {
+		d := time.Duration(i) * time.Millisecond
+		if i%2 == 0 {
+			a.Add(d)
+		} else {
+			b.Add(d * 100)
+			d *= 100
+		}
+		all.Add(d)
+	}
+	a.Add(0)
+	all.Add(0)
+
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []float64{0, 0.25, 0.5, 0.99} {
+		if a.Quantile(q) != all.Quantile(q) {
+			t.Errorf("q=%v: merged %v, want %v", q, a.Quantile(q), all.Quantile(q))
+		}
+	}
+	if err := a.Merge(NewLatencySketch(0.05)); err != nil {
+		t.Errorf("expected merging an empty sketch to succeed, got %v", err)
+	}
+	other := NewLatencySketch(0.05)
+	other.Add(time.Second)
+	if err := a.Merge(other); err == nil {
+		t.Error("expected an error merging sketches with different accuracy")
+	}
+}
+
+func TestLatencySketchJSON(t *testing.T) {
+	s := NewLatencySketch(0.02)
+	for i := 0; i < 100; i++ {
+		s.Add(time.Duration(i) * time.Millisecond)
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got LatencySketch
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Count() != 100 || got.RelativeAccuracy() != 0.02 || got.Quantile(0.95) != s.Quantile(0.95) {
+		t.Errorf("sketch changed in a JSON round trip: %s", data)
+	}
+	if err := got.Merge(s); err != nil || got.Count() != 200 {
+		t.Errorf("expected a decoded sketch to merge, got %v", err)
+	}
+	if err := json.Unmarshal([]byte(`{"relative_accuracy":0,"bins":[1]}`), &got); err == nil {
+		t.Error("expected an error for an invalid accuracy")
+	}
+}
+
+func TestLatencySketchBoundedBins(t *testing.T) {
+	s := NewLatencySketch(0.2)
+	for d := time.Duration(1); d < time.Duration(math.MaxInt64/2); d *= 2 {
+		s.Add(d)
+	}
+	for d := time.Duration(math.MaxInt64 / 2); d > 0; d /= 3 {
+		s.Add(d)
+	}
+	if len(s.bins) > sketchMaxBins {
+		t.Errorf("expected at most %d bins, got %d", sketchMaxBins, len(s.bins))
+	}
+
+	tiny := NewLatencySketch(0.0001)
+	tiny.Add(time.Nanosecond)
+	tiny.Add(time.Hour)
+	if len(tiny.bins) != sketchMaxBins || tiny.Count() != 2 {
+		t.Errorf("expected the lowest bins collapsed, got %d bins", len(tiny.bins))
+	}
+	if tiny.Quantile(1) < 59*time.Minute {
+		t.Errorf("expected the highest value kept accurately, got %v", tiny.Quantile(1))
+	}
+}
+
+func TestLatencySketchKeepsCountsWhileGrowing(t *testing.T) {
+	s := NewLatencySketch(0.01)
+	merged := NewLatencySketch(0.01)
+	// Each value lands outside the current range, so bins is reallocated on
+	// every Add and Merge.
+	for d := time.Hour; d > time.Microsecond; d /= 2 {
+		s.Add(d)
+		one := NewLatencySketch(0.01)
+		one.Add(d * 3)
+		merged.Merge(one)
+	}
+	for _, sk := range []*LatencySketch{s, merged} {
+		var total uint64
+		for _, n := range sk.bins {
+			total += n
+		}
+		if total != sk.Count() {
+			t.Errorf("expected %d values in bins, got %d", sk.Count(), total)
+		}
+	}
+}
+
+func TestRouteMetricsPercentilesStayCurrent(t *testing.T) {
+	rm := NewRouteMetrics("/test", "GET", 10000)
+	for i := 0; i < 20000; i++ {
+		rm.Record(&RequestTrace{Latency: time.Millisecond, ResponseStatus: 200})
+	}
+	for i := 0; i < 80000; i++ {
+		rm.Record(&RequestTrace{Latency: time.Second, ResponseStatus: 200})
+	}
+	if p50 := rm.P50(); p50 < 990*time.Millisecond || p50 > 1010*time.Millisecond {
+		t.Errorf("expected P50 to follow later traffic, got %v", p50)
+	}
+	snap := rm.Snapshot()
+	rm.Record(&RequestTrace{Latency: time.Hour})
+	if snap.Latency.Count() != 100000 {
+		t.Error("expected the snapshot's sketch to be independent of the live one")
+	}
+}
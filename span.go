@@ -0,0 +1,108 @@
+package xrayhq
+
+import (
+	"context"
+	"time"
+)
+
+var spanKey = &contextKey{"xrayhq-span"}
+
+// Span marks a unit of application work inside a request, such as rendering
+// a template or calling a pricing engine. Spans nest through the context:
+// a span started from a context that already carries one becomes its child,
+// and DB, Redis, Mongo and HTTP operations recorded with that context are
+// attached to it.
+type Span struct {
+	ID        string
+	ParentID  string // empty for spans directly under the request
+	Name      string
+	StartTime time.Time
+	EndTime   time.Time
+	Duration  time.Duration
+	Attrs     map[string]interface{}
+	Error     string
+
+	rec *traceRecorder
+}
+
+// StartSpan starts a span named name as a child of the span in ctx, or of
+// the request itself. The returned context carries the new span. Call End
+// when the work is done; spans still open when the request finishes are
+// ended with it.
+//
+// Outside a traced request StartSpan returns a span whose methods do nothing.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	span := &Span{
+		ID:        generateSpanID(),
+		Name:      name,
+		StartTime: time.Now(),
+	}
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.ParentID = parent.ID
+	}
+	if rec := recorderFromContext(ctx); rec != nil {
+		span.rec = rec
+		rec.addOp(func(t *RequestTrace) { t.Spans = append(t.Spans, span) })
+	}
+	return context.WithValue(ctx, spanKey, span), span
+}
+
+// SpanFromContext returns the current span in ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey).(*Span)
+	return s
+}
+
+// SetAttr sets an attribute shown with the span on the request detail page.
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil || s.rec == nil {
+		return
+	}
+	s.rec.mutate(func(*RequestTrace) {
+		if s.Attrs == nil {
+			s.Attrs = make(map[string]interface{})
+		}
+		s.Attrs[key] = value
+	})
+}
+
+// RecordError marks the span as failed with err. A nil err is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || s.rec == nil || err == nil {
+		return
+	}
+	s.rec.mutate(func(*RequestTrace) { s.Error = err.Error() })
+}
+
+// End finishes the span. Only the first call has an effect.
+func (s *Span) End() {
+	if s == nil || s.rec == nil {
+		return
+	}
+	end := time.Now()
+	s.rec.mutate(func(*RequestTrace) { s.end(end) })
+}
+
+func (s *Span) end(at time.Time) {
+	if !s.EndTime.IsZero() {
+		return
+	}
+	s.EndTime = at
+	s.Duration = at.Sub(s.StartTime)
+}
+
+// currentSpanID returns the ID of the span in ctx, or "" if there is none.
+func currentSpanID(ctx context.Context) string {
+	if s := SpanFromContext(ctx); s != nil {
+		return s.ID
+	}
+	return ""
+}
+
+// endOpenSpans ends spans the handler never ended at the request's end time.
+// It must only be called on a sealed trace.
+func endOpenSpans(t *RequestTrace, at time.Time) {
+	for _, s := range t.Spans {
+		s.end(at)
+	}
+}
@@ -0,0 +1,153 @@
+package xrayhq
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSpansNestThroughContext(t *testing.T) {
+	c, cfg := setupTestCollector()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, outer := StartSpan(r.Context(), "load order")
+		outer.SetAttr("order_id", 42)
+
+		innerCtx, inner := StartSpan(ctx, "call pricing engine")
+		AddExternalCall(innerCtx, ExternalCall{URL: "http://pricing/quote", Duration: time.Millisecond, Timestamp: time.Now()})
+		inner.RecordError(errors.New("pricing unavailable"))
+		inner.End()
+
+		AddDBQuery(ctx, DBQuery{Query: "SELECT * FROM orders", Duration: time.Millisecond, Timestamp: time.Now()})
+		outer.End()
+
+		AddDBQuery(r.Context(), DBQuery{Query: "SELECT 1", Timestamp: time.Now()})
+	})
+
+	wrapped := coreMiddleware(c, cfg, handler)
+	wrapped.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/orders/42", nil))
+
+	trace := c.GetRecentRequests(1)[0]
+	if len(trace.Spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(trace.Spans))
+	}
+	outer, inner := trace.Spans[0], trace.Spans[1]
+	if outer.ParentID != "" || inner.ParentID != outer.ID {
+		t.Errorf("expected inner span to be a child of outer, got parents %q and %q", outer.ParentID, inner.ParentID)
+	}
+	if outer.Attrs["order_id"] != 42 {
+		t.Errorf("expected order_id attribute, got %v", outer.Attrs)
+	}
+	if inner.Error != "pricing unavailable" {
+		t.Errorf("expected recorded error, got %q", inner.Error)
+	}
+	if outer.Duration <= 0 || outer.Duration < inner.Duration {
+		t.Errorf("expected outer span to cover inner span, got %v and %v", outer.Duration, inner.Duration)
+	}
+	if trace.ExternalCalls[0].ParentSpanID != inner.ID {
+		t.Errorf("expected external call attached to inner span")
+	}
+	if trace.DBQueries[0].ParentSpanID != outer.ID || trace.DBQueries[1].ParentSpanID != "" {
+		t.Errorf("expected queries attached to outer span and request, got %q and %q",
+			trace.DBQueries[0].ParentSpanID, trace.DBQueries[1].ParentSpanID)
+	}
+}
+
+func TestSpanOutsideRequestIsNoop(t *testing.T) {
+	ctx, span := StartSpan(context.Background(), "background job")
+	span.SetAttr("k", "v")
+	span.RecordError(errors.New("boom"))
+	span.End()
+
+	if SpanFromContext(ctx) != span {
+		t.Error("expected span in returned context")
+	}
+	if span.Attrs != nil || span.Error != "" {
+		t.Error("expected span outside a request to ignore updates")
+	}
+}
+
+func TestUnendedSpansEndWithRequest(t *testing.T) {
+	c, cfg := setupTestCollector()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		StartSpan(r.Context(), "forgotten")
+	})
+
+	wrapped := coreMiddleware(c, cfg, handler)
+	wrapped.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	span := c.GetRecentRequests(1)[0].Spans[0]
+	if span.EndTime.IsZero() {
+		t.Error("expected open span to be ended with the request")
+	}
+}
+
+func TestBuildWaterfallTree(t *testing.T) {
+	start := time.Now()
+	trace := &RequestTrace{
+		Method:    "GET",
+		Path:      "/checkout",
+		StartTime: start,
+		Latency:   100 * time.Millisecond,
+		Spans: []*Span{
+			{ID: "a", Name: "validate", StartTime: start, Duration: 40 * time.Millisecond},
+			{ID: "b", ParentID: "a", Name: "load rules", StartTime: start.Add(time.Millisecond), Duration: 10 * time.Millisecond},
+		},
+		DBQueries: []DBQuery{
+			{Query: "SELECT rules", ParentSpanID: "b", Timestamp: start.Add(2 * time.Millisecond), Duration: 5 * time.Millisecond},
+			{Query: "SELECT cart", Timestamp: start.Add(50 * time.Millisecond), Duration: 20 * time.Millisecond},
+		},
+	}
+
+	rows := buildWaterfall(trace)
+	want := []struct {
+		label string
+		depth int
+		self  time.Duration
+	}{
+		{"Handler", 0, 40 * time.Millisecond},
+		{"validate", 1, 30 * time.Millisecond},
+		{"load rules", 2, 5 * time.Millisecond},
+		{"DB: SELECT rules", 3, 5 * time.Millisecond},
+		{"DB: SELECT cart", 1, 20 * time.Millisecond},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("expected %d rows, got %d", len(want), len(rows))
+	}
+	for i, w := range want {
+		if rows[i].Label != w.label || rows[i].Depth != w.depth || rows[i].SelfTime != w.self {
+			t.Errorf("row %d: got %s depth=%d self=%v, want %s depth=%d self=%v",
+				i, rows[i].Label, rows[i].Depth, rows[i].SelfTime, w.label, w.depth, w.self)
+		}
+	}
+}
+
+func TestWaterfallSelfTimeWithOverlappingChildren(t *testing.T) {
+	start := time.Now()
+	ms := func(n int) time.Duration { return time.Duration(n) * time.Millisecond }
+	trace := &RequestTrace{
+		Method:    "GET",
+		Path:      "/fanout",
+		StartTime: start,
+		Latency:   ms(100),
+		// Three goroutines fanned out at 10ms, overlapping until 50ms, one
+		// query from 70ms, and one that outlived the request.
+		DBQueries: []DBQuery{
+			{Query: "A", Timestamp: start.Add(ms(10)), Duration: ms(30)},
+			{Query: "B", Timestamp: start.Add(ms(10)), Duration: ms(40)},
+			{Query: "C", Timestamp: start.Add(ms(20)), Duration: ms(10)},
+			{Query: "D", Timestamp: start.Add(ms(70)), Duration: ms(10)},
+			{Query: "E", Timestamp: start.Add(ms(90)), Duration: ms(30)},
+		},
+	}
+
+	rows := buildWaterfall(trace)
+	// Covered: 10-50, 70-80 and 90-100, clipped to the request.
+	if got := rows[0].SelfTime; got != ms(40) {
+		t.Errorf("expected handler self time 40ms, got %v", got)
+	}
+}
@@ -0,0 +1,57 @@
+package xrayhq
+
+import "time"
+
+// Store keeps recorded traces for the dashboard and export. Implementations
+// must be safe for concurrent use. The default keeps recent traces in memory;
+// DiskStore keeps traces across restarts.
+type Store interface {
+	// Append stores a finished trace. The trace must not be modified
+	// afterwards.
+	Append(t *RequestTrace) error
+	// Get returns the trace with the given ID, or nil if there is none.
+	Get(id string) (*RequestTrace, error)
+	// Iterate calls fn for every trace matching filter, newest first, until
+	// fn returns false.
+	Iterate(filter TraceFilter, fn func(*RequestTrace) bool) error
+	// Evict removes traces that started before t and returns how many were
+	// removed.
+	Evict(before time.Time) (int, error)
+	// Len returns the number of stored traces.
+	Len() int
+	// Close releases the store's resources.
+	Close() error
+}
+
+// TraceFilter selects traces in Store.Iterate. Zero fields match anything.
+type TraceFilter struct {
+	Method       string
+	RoutePattern string
+	MinStatus    int // inclusive
+	MaxStatus    int // inclusive
+	Since        time.Time
+	Until        time.Time
+}
+
+// Match reports whether t passes the filter.
+func (f TraceFilter) Match(t *RequestTrace) bool {
+	return f.matchFields(t.Method, t.RoutePattern, t.ResponseStatus, t.StartTime)
+}
+
+func (f TraceFilter) matchFields(method, route string, status int, start time.Time) bool {
+	switch {
+	case f.Method != "" && f.Method != method:
+		return false
+	case f.RoutePattern != "" && f.RoutePattern != route:
+		return false
+	case f.MinStatus != 0 && status < f.MinStatus:
+		return false
+	case f.MaxStatus != 0 && status > f.MaxStatus:
+		return false
+	case !f.Since.IsZero() && start.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !start.Before(f.Until):
+		return false
+	}
+	return true
+}
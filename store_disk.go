@@ -0,0 +1,452 @@
+package xrayhq
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DiskStoreOptions configures a DiskStore. Zero values use the defaults.
+type DiskStoreOptions struct {
+	// SegmentBytes is the size at which the current segment file is closed
+	// and a new one started. Defaults to 8MB.
+	SegmentBytes int64
+	// MaxBytes caps the total size of all segments. The oldest segments are
+	// deleted to stay under it. Zero means no limit.
+	MaxBytes int64
+	// MaxAge deletes segments whose newest trace is older than this,
+	// checked in the background as well as on writes. Zero means no limit.
+	MaxAge time.Duration
+}
+
+const defaultSegmentBytes = 8 << 20
+
+// DiskStore is a Store that appends traces as JSON lines to segment files in
+// a directory, so they survive restarts. An in-memory index of every trace's
+// ID, route, status and start time is rebuilt from the segments on open.
+// Retention removes whole segments, oldest first.
+type DiskStore struct {
+	mu       sync.RWMutex
+	dir      string
+	opts     DiskStoreOptions
+	segments []*diskSegment // oldest first; the last one is written to
+	index    map[string]*diskEntry
+	size     int64
+	live     int
+	nextSeq  int
+	closed   bool
+	now      func() time.Time
+
+	// stop and done control the goroutine applying MaxAge.
+	stop chan struct{}
+	done chan struct{}
+}
+
+type diskSegment struct {
+	seq     int
+	path    string
+	file    *os.File
+	size    int64
+	entries []*diskEntry
+	live    int
+	newest  time.Time
+}
+
+// diskEntry is the index record of one stored trace.
+type diskEntry struct {
+	seg     *diskSegment
+	offset  int64
+	length  int
+	id      string
+	method  string
+	route   string
+	status  int
+	start   time.Time
+	evicted bool
+}
+
+// diskHeader is the part of a stored trace decoded when rebuilding the
+// index.
+type diskHeader struct {
+	ID             string
+	Method         string
+	RoutePattern   string
+	ResponseStatus int
+	StartTime      time.Time
+}
+
+var errStoreClosed = errors.New("xrayhq: store is closed")
+
+// NewDiskStore opens or creates a DiskStore in dir.
+func NewDiskStore(dir string, opts DiskStoreOptions) (*DiskStore, error) {
+	if opts.SegmentBytes <= 0 {
+		opts.SegmentBytes = defaultSegmentBytes
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("xrayhq: disk store: %w", err)
+	}
+	s := &DiskStore{
+		dir:   dir,
+		opts:  opts,
+		index: make(map[string]*diskEntry),
+		now:   time.Now,
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "segment-*.ndjson"))
+	if err != nil {
+		return nil, fmt.Errorf("xrayhq: disk store: %w", err)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		var seq int
+		if _, err := fmt.Sscanf(filepath.Base(path), "segment-%d.ndjson", &seq); err != nil {
+			continue
+		}
+		seg, err := s.loadSegment(path, seq)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.segments = append(s.segments, seg)
+		s.nextSeq = seq + 1
+	}
+	if len(s.segments) == 0 {
+		if err := s.rotate(); err != nil {
+			return nil, err
+		}
+	}
+	s.enforceRetention()
+	if opts.MaxAge > 0 {
+		s.stop = make(chan struct{})
+		s.done = make(chan struct{})
+		go s.expireLoop(min(max(opts.MaxAge/10, time.Millisecond), time.Minute))
+	}
+	return s, nil
+}
+
+// expireLoop applies MaxAge every interval, so segments expire even when
+// nothing is written.
+func (s *DiskStore) expireLoop(interval time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if !s.closed {
+				s.enforceRetention()
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// loadSegment opens a segment and indexes its traces. A partial last line,
+// left by a crash mid-write, is cut off.
+func (s *DiskStore) loadSegment(path string, seq int) (*diskSegment, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("xrayhq: disk store: %w", err)
+	}
+	seg := &diskSegment{seq: seq, path: path, file: f}
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("xrayhq: disk store: %w", err)
+		}
+		var h diskHeader
+		if json.Unmarshal(line, &h) == nil && h.ID != "" {
+			s.addEntry(seg, h, seg.size, len(line))
+		}
+		seg.size += int64(len(line))
+	}
+	if err := f.Truncate(seg.size); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("xrayhq: disk store: %w", err)
+	}
+	if _, err := f.Seek(seg.size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("xrayhq: disk store: %w", err)
+	}
+	s.size += seg.size
+	return seg, nil
+}
+
+func (s *DiskStore) addEntry(seg *diskSegment, h diskHeader, offset int64, length int) {
+	e := &diskEntry{
+		seg:    seg,
+		offset: offset,
+		length: length,
+		id:     h.ID,
+		method: h.Method,
+		route:  h.RoutePattern,
+		status: h.ResponseStatus,
+		start:  h.StartTime,
+	}
+	seg.entries = append(seg.entries, e)
+	seg.live++
+	if h.StartTime.After(seg.newest) {
+		seg.newest = h.StartTime
+	}
+	s.index[h.ID] = e
+	s.live++
+}
+
+// rotate starts a new segment. s.mu must be held.
+func (s *DiskStore) rotate() error {
+	path := filepath.Join(s.dir, fmt.Sprintf("segment-%08d.ndjson", s.nextSeq))
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("xrayhq: disk store: %w", err)
+	}
+	s.segments = append(s.segments, &diskSegment{seq: s.nextSeq, path: path, file: f})
+	s.nextSeq++
+	return nil
+}
+
+// enforceRetention deletes the oldest segments while the store is over
+// MaxBytes or they have aged past MaxAge. The segment being written to is
+// never deleted; once it has aged past MaxAge itself, a new one is started
+// so it can be. s.mu must be held.
+func (s *DiskStore) enforceRetention() {
+	var cutoff time.Time
+	if s.opts.MaxAge > 0 {
+		cutoff = s.now().Add(-s.opts.MaxAge)
+		if last := s.segments[len(s.segments)-1]; last.size > 0 && last.newest.Before(cutoff) {
+			s.rotate()
+		}
+	}
+	for len(s.segments) > 1 {
+		oldest := s.segments[0]
+		overSize := s.opts.MaxBytes > 0 && s.size > s.opts.MaxBytes
+		expired := !cutoff.IsZero() && oldest.newest.Before(cutoff)
+		if !overSize && !expired && oldest.live > 0 {
+			return
+		}
+		s.dropSegment(oldest)
+		s.segments = s.segments[1:]
+	}
+}
+
+func (s *DiskStore) dropSegment(seg *diskSegment) {
+	for _, e := range seg.entries {
+		if !e.evicted {
+			e.evicted = true
+			delete(s.index, e.id)
+			s.live--
+		}
+	}
+	seg.live = 0
+	s.size -= seg.size
+	seg.file.Close()
+	os.Remove(seg.path)
+}
+
+func (s *DiskStore) Append(t *RequestTrace) error {
+	line, err := marshalStoredTrace(t)
+	if err != nil {
+		return fmt.Errorf("xrayhq: disk store: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errStoreClosed
+	}
+	seg := s.segments[len(s.segments)-1]
+	if seg.size > 0 && seg.size+int64(len(line)) > s.opts.SegmentBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+		seg = s.segments[len(s.segments)-1]
+	}
+	if _, err := seg.file.Write(line); err != nil {
+		s.discardPartialWrite(seg)
+		return fmt.Errorf("xrayhq: disk store: %w", err)
+	}
+	h := diskHeader{ID: t.ID, Method: t.Method, RoutePattern: t.RoutePattern, ResponseStatus: t.ResponseStatus, StartTime: t.StartTime}
+	if old, ok := s.index[t.ID]; ok {
+		s.evictEntry(old)
+	}
+	s.addEntry(seg, h, seg.size, len(line))
+	seg.size += int64(len(line))
+	s.size += int64(len(line))
+	s.enforceRetention()
+	return nil
+}
+
+// discardPartialWrite cuts off whatever part of a failed write reached the
+// segment, so the offsets of later entries stay right. If the segment cannot
+// be truncated, writing moves on to a new one. s.mu must be held.
+func (s *DiskStore) discardPartialWrite(seg *diskSegment) {
+	if err := seg.file.Truncate(seg.size); err == nil {
+		if _, err := seg.file.Seek(seg.size, io.SeekStart); err == nil {
+			return
+		}
+	}
+	s.rotate()
+}
+
+// marshalStoredTrace encodes t as one JSON line. Panic values are stored as
+// their printed form, since arbitrary values do not round-trip through JSON.
+func marshalStoredTrace(t *RequestTrace) ([]byte, error) {
+	if t.PanicValue != nil {
+		cp := *t
+		cp.PanicValue = fmt.Sprint(t.PanicValue)
+		t = &cp
+	}
+	line, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// read loads the trace an entry points to.
+func (s *DiskStore) read(e *diskEntry) (*RequestTrace, error) {
+	buf := make([]byte, e.length)
+	if _, err := e.seg.file.ReadAt(buf, e.offset); err != nil {
+		return nil, fmt.Errorf("xrayhq: disk store: %w", err)
+	}
+	t := &RequestTrace{}
+	if err := json.Unmarshal(buf, t); err != nil {
+		return nil, fmt.Errorf("xrayhq: disk store: %w", err)
+	}
+	return t, nil
+}
+
+func (s *DiskStore) Get(id string) (*RequestTrace, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil, errStoreClosed
+	}
+	e, ok := s.index[id]
+	if !ok {
+		return nil, nil
+	}
+	return s.read(e)
+}
+
+// Iterate filters on the index and only reads matching traces from disk.
+// Traces removed by retention while iterating are skipped.
+func (s *DiskStore) Iterate(filter TraceFilter, fn func(*RequestTrace) bool) error {
+	s.mu.RLock()
+	if s.closed {
+		s.mu.RUnlock()
+		return errStoreClosed
+	}
+	var matches []*diskEntry
+	for i := len(s.segments) - 1; i >= 0; i-- {
+		entries := s.segments[i].entries
+		for j := len(entries) - 1; j >= 0; j-- {
+			e := entries[j]
+			if !e.evicted && filter.matchFields(e.method, e.route, e.status, e.start) {
+				matches = append(matches, e)
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, e := range matches {
+		s.mu.RLock()
+		if e.evicted || s.closed {
+			s.mu.RUnlock()
+			continue
+		}
+		t, err := s.read(e)
+		s.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+		if !fn(t) {
+			break
+		}
+	}
+	return nil
+}
+
+// Evict removes traces that started before the given time from the index.
+// Their disk space is reclaimed once every trace in a segment is gone.
+func (s *DiskStore) Evict(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, errStoreClosed
+	}
+	n := 0
+	for _, seg := range s.segments {
+		for _, e := range seg.entries {
+			if !e.evicted && e.start.Before(before) {
+				s.evictEntry(e)
+				n++
+			}
+		}
+	}
+	s.enforceRetention()
+	return n, nil
+}
+
+func (s *DiskStore) evictEntry(e *diskEntry) {
+	e.evicted = true
+	delete(s.index, e.id)
+	e.seg.live--
+	s.live--
+}
+
+func (s *DiskStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.live
+}
+
+// Size returns the total size of the segment files in bytes.
+func (s *DiskStore) Size() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.size
+}
+
+// Dir returns the directory the store writes to.
+func (s *DiskStore) Dir() string { return s.dir }
+
+// Close closes the segment files. Further calls return an error.
+func (s *DiskStore) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	var errs []error
+	for _, seg := range s.segments {
+		if seg.file != nil {
+			if err := seg.file.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,402 @@
+package xrayhq
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStoreOptions configures the in-memory store. At least one limit
+// must be set.
+type MemoryStoreOptions struct {
+	// MaxTraces caps the number of traces kept. Zero means no cap.
+	MaxTraces int
+	// MaxBytes caps the estimated memory held by traces. Zero means no cap.
+	MaxBytes int64
+	// ShedBodies drops the captured bodies of the oldest traces before
+	// evicting whole traces to get back under MaxBytes.
+	ShedBodies bool
+}
+
+// memoryStore is a ring that evicts the oldest traces once it holds
+// MaxTraces of them or their estimated size exceeds MaxBytes. It grows on
+// demand up to MaxTraces.
+//
+// Every trace gets a sequence number in append order. Indexes by ID, route
+// and status refer to sequence numbers, which stay valid while the ring
+// grows, and are trimmed from the front as the oldest traces are evicted.
+type memoryStore struct {
+	mu    sync.RWMutex
+	opts  MemoryStoreOptions
+	buf   []memoryEntry
+	head  int    // position of the oldest entry
+	first uint64 // sequence number of the oldest entry
+	count int
+	bytes int64
+	// shedScan is how many of the oldest entries have already been checked
+	// for bodies to shed.
+	shedScan int
+
+	byID     map[string]uint64
+	byRoute  map[string][]uint64 // "METHOD pattern" -> ascending sequence numbers
+	byStatus map[int][]uint64
+}
+
+type memoryEntry struct {
+	trace *RequestTrace
+	size  int64
+	// maxStart is the latest StartTime of this and all older entries. It
+	// never decreases along the ring, so time ranges can be binary searched
+	// even though traces are stored in the order they finished.
+	maxStart time.Time
+}
+
+// Iterate collects traces in batches, one per lock acquisition. The first
+// batch is small so that a caller wanting only a few traces stops early, and
+// each following batch is twice as large, up to iterateMaxBatch.
+const (
+	iterateFirstBatch = 16
+	iterateMaxBatch   = 256
+)
+
+// NewMemoryStore returns a Store that keeps the size most recent traces in
+// memory. It panics if size is not positive.
+func NewMemoryStore(size int) Store {
+	if size <= 0 {
+		panic("xrayhq: memory store size must be positive")
+	}
+	return NewMemoryStoreWithOptions(MemoryStoreOptions{MaxTraces: size})
+}
+
+// NewMemoryStoreWithOptions returns a Store that keeps the most recent
+// traces in memory within the given limits. It panics if neither MaxTraces
+// nor MaxBytes is positive.
+func NewMemoryStoreWithOptions(opts MemoryStoreOptions) Store {
+	if opts.MaxTraces <= 0 && opts.MaxBytes <= 0 {
+		panic("xrayhq: memory store needs MaxTraces or MaxBytes")
+	}
+	initial := 64
+	if opts.MaxTraces > 0 && opts.MaxTraces < initial {
+		initial = opts.MaxTraces
+	}
+	return &memoryStore{
+		opts:     opts,
+		buf:      make([]memoryEntry, initial),
+		byID:     make(map[string]uint64),
+		byRoute:  make(map[string][]uint64),
+		byStatus: make(map[int][]uint64),
+	}
+}
+
+func routeKey(method, pattern string) string {
+	return method + " " + pattern
+}
+
+func (s *memoryStore) Append(t *RequestTrace) error {
+	size := estimateTraceSize(t)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.opts.MaxTraces > 0 && s.count >= s.opts.MaxTraces {
+		s.evictOldest()
+	}
+	if s.count == len(s.buf) {
+		s.grow()
+	}
+
+	maxStart := t.StartTime
+	if s.count > 0 {
+		if prev := s.entry(s.first + uint64(s.count) - 1).maxStart; prev.After(maxStart) {
+			maxStart = prev
+		}
+	}
+	seq := s.first + uint64(s.count)
+	s.buf[(s.head+s.count)%len(s.buf)] = memoryEntry{trace: t, size: size, maxStart: maxStart}
+	s.count++
+	s.bytes += size
+
+	s.byID[t.ID] = seq
+	key := routeKey(t.Method, t.RoutePattern)
+	s.byRoute[key] = append(s.byRoute[key], seq)
+	s.byStatus[t.ResponseStatus] = append(s.byStatus[t.ResponseStatus], seq)
+
+	if s.opts.MaxBytes > 0 {
+		// The newest trace is always kept, even on its own over budget.
+		for s.bytes > s.opts.MaxBytes && s.count > 1 {
+			if !s.opts.ShedBodies || !s.shedNext() {
+				s.evictOldest()
+			}
+		}
+	}
+	return nil
+}
+
+// entry returns the entry with sequence number seq, which must be stored.
+func (s *memoryStore) entry(seq uint64) *memoryEntry {
+	return &s.buf[(s.head+int(seq-s.first))%len(s.buf)]
+}
+
+func (s *memoryStore) grow() {
+	n := 2 * len(s.buf)
+	if s.opts.MaxTraces > 0 && n > s.opts.MaxTraces {
+		n = s.opts.MaxTraces
+	}
+	buf := make([]memoryEntry, n)
+	for i := 0; i < s.count; i++ {
+		buf[i] = s.buf[(s.head+i)%len(s.buf)]
+	}
+	s.buf, s.head = buf, 0
+}
+
+// shedNext drops the bodies of the oldest trace that still has some, other
+// than the newest. It reports false once there are none left to drop. The
+// stored trace is replaced by a copy, since readers may hold the original.
+func (s *memoryStore) shedNext() bool {
+	for s.shedScan < s.count-1 {
+		e := &s.buf[(s.head+s.shedScan)%len(s.buf)]
+		s.shedScan++
+		if len(e.trace.RequestBody) == 0 && len(e.trace.ResponseBody) == 0 {
+			continue
+		}
+		shed := *e.trace
+		shed.RequestBody, shed.ResponseBody = nil, nil
+		shed.BodiesShed = true
+		size := estimateTraceSize(&shed)
+		s.bytes -= e.size - size
+		e.trace, e.size = &shed, size
+		return true
+	}
+	return false
+}
+
+// evictOldest removes the oldest entry from the ring and the indexes. Since
+// eviction is oldest first, the entry is always at the front of its route
+// and status lists.
+func (s *memoryStore) evictOldest() {
+	seq := s.first
+	e := &s.buf[s.head]
+	t := e.trace
+
+	if s.byID[t.ID] == seq {
+		delete(s.byID, t.ID)
+	}
+	key := routeKey(t.Method, t.RoutePattern)
+	if list := s.byRoute[key][1:]; len(list) > 0 {
+		s.byRoute[key] = list
+	} else {
+		delete(s.byRoute, key)
+	}
+	if list := s.byStatus[t.ResponseStatus][1:]; len(list) > 0 {
+		s.byStatus[t.ResponseStatus] = list
+	} else {
+		delete(s.byStatus, t.ResponseStatus)
+	}
+
+	s.bytes -= e.size
+	*e = memoryEntry{}
+	s.head = (s.head + 1) % len(s.buf)
+	s.first++
+	s.count--
+	if s.shedScan > 0 {
+		s.shedScan--
+	}
+}
+
+func (s *memoryStore) Get(id string) (*RequestTrace, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if seq, ok := s.byID[id]; ok {
+		return s.entry(seq).trace, nil
+	}
+	return nil, nil
+}
+
+// Iterate walks the smallest of the candidate sets the filter allows: the
+// route's list, the lists of the statuses in range, or the entries that can
+// have started after filter.Since. The lock is held for one batch at a time,
+// so recording is never blocked for long and fn may call back into the
+// store.
+func (s *memoryStore) Iterate(filter TraceFilter, fn func(*RequestTrace) bool) error {
+	cursor := uint64(math.MaxUint64) // sequence numbers below this are left
+	for limit := iterateFirstBatch; ; limit = min(2*limit, iterateMaxBatch) {
+		s.mu.RLock()
+		batch, done := s.nextBatch(filter, &cursor, limit)
+		s.mu.RUnlock()
+
+		for _, t := range batch {
+			if !fn(t) {
+				return nil
+			}
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// nextBatch collects up to limit traces matching filter with
+// sequence numbers below *cursor, newest first, and moves the cursor past
+// them. done reports that no candidates are left.
+func (s *memoryStore) nextBatch(filter TraceFilter, cursor *uint64, limit int) (batch []*RequestTrace, done bool) {
+	if s.count == 0 {
+		return nil, true
+	}
+	low := s.first
+	if !filter.Since.IsZero() {
+		low += uint64(sort.Search(s.count, func(i int) bool {
+			return !s.entry(s.first + uint64(i)).maxStart.Before(filter.Since)
+		}))
+	}
+	high := s.first + uint64(s.count)
+	if *cursor < high {
+		high = *cursor
+	}
+	if high <= low {
+		return nil, true
+	}
+
+	lists, indexed := s.candidateLists(filter, high-low)
+	if !indexed {
+		for seq := high; seq > low; {
+			seq--
+			*cursor = seq
+			if t := s.entry(seq).trace; filter.Match(t) {
+				batch = append(batch, t)
+				if len(batch) == limit {
+					return batch, false
+				}
+			}
+		}
+		return batch, true
+	}
+
+	// Merge the ascending lists newest first, starting below the cursor.
+	pos := make([]int, len(lists))
+	for i, list := range lists {
+		pos[i] = sort.Search(len(list), func(j int) bool { return list[j] >= high }) - 1
+	}
+	for {
+		best := -1
+		for i, list := range lists {
+			if pos[i] >= 0 && list[pos[i]] >= low && (best < 0 || list[pos[i]] > lists[best][pos[best]]) {
+				best = i
+			}
+		}
+		if best < 0 {
+			return batch, true
+		}
+		seq := lists[best][pos[best]]
+		pos[best]--
+		*cursor = seq
+		if t := s.entry(seq).trace; filter.Match(t) {
+			batch = append(batch, t)
+			if len(batch) == limit {
+				return batch, false
+			}
+		}
+	}
+}
+
+// candidateLists returns the index lists that together hold every trace
+// matching filter, if that is fewer than scanSize entries.
+func (s *memoryStore) candidateLists(filter TraceFilter, scanSize uint64) (lists [][]uint64, ok bool) {
+	best := scanSize
+	consider := func(candidate [][]uint64) {
+		n := uint64(0)
+		for _, l := range candidate {
+			n += uint64(len(l))
+		}
+		if n < best {
+			best, lists, ok = n, candidate, true
+		}
+	}
+
+	if filter.RoutePattern != "" {
+		var candidate [][]uint64
+		if filter.Method != "" {
+			candidate = append(candidate, s.byRoute[routeKey(filter.Method, filter.RoutePattern)])
+		} else {
+			for key, list := range s.byRoute {
+				if _, pattern, _ := strings.Cut(key, " "); pattern == filter.RoutePattern {
+					candidate = append(candidate, list)
+				}
+			}
+		}
+		consider(candidate)
+	}
+	if filter.MinStatus != 0 || filter.MaxStatus != 0 {
+		var candidate [][]uint64
+		for status, list := range s.byStatus {
+			if (filter.MinStatus == 0 || status >= filter.MinStatus) && (filter.MaxStatus == 0 || status <= filter.MaxStatus) {
+				candidate = append(candidate, list)
+			}
+		}
+		consider(candidate)
+	}
+	return lists, ok
+}
+
+// Evict drops traces from the oldest end of the ring until it reaches one
+// that started at or after before.
+func (s *memoryStore) Evict(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for s.count > 0 && s.buf[s.head].trace.StartTime.Before(before) {
+		s.evictOldest()
+		n++
+	}
+	return n, nil
+}
+
+func (s *memoryStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.count
+}
+
+// Usage returns the estimated bytes held by stored traces and the budget,
+// which is zero when there is none.
+func (s *memoryStore) Usage() (bytes, budget int64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.bytes, s.opts.MaxBytes
+}
+
+func (s *memoryStore) Close() error { return nil }
+
+// estimateTraceSize approximates the memory a trace holds on to: a fixed
+// allowance for the struct plus its strings, bodies, headers, operations,
+// spans, stack and alerts.
+func estimateTraceSize(t *RequestTrace) int64 {
+	n := 512 + len(t.ID) + len(t.TraceState) + len(t.Path) + len(t.RoutePattern) +
+		len(t.QueryParams) + len(t.ClientIP) + len(t.UserAgent) +
+		len(t.RequestBody) + len(t.ResponseBody) + len(t.PanicStack)
+	for k, v := range t.RequestHeaders {
+		n += 32 + len(k) + len(v)
+	}
+	for k, v := range t.ResponseHeaders {
+		n += 32 + len(k) + len(v)
+	}
+	for _, q := range t.DBQueries {
+		n += 96 + len(q.Query) + len(q.Error) + len(q.ParentSpanID)
+	}
+	for _, c := range t.ExternalCalls {
+		n += 128 + len(c.URL) + len(c.Method) + len(c.Error)
+	}
+	for _, op := range t.RedisOps {
+		n += 96 + len(op.Command) + len(op.Key) + len(op.Error)
+	}
+	for _, op := range t.MongoOps {
+		n += 112 + len(op.Collection) + len(op.Operation) + len(op.Filter) + len(op.Error)
+	}
+	for _, sp := range t.Spans {
+		n += 128 + len(sp.Name) + len(sp.Error) + 48*len(sp.Attrs)
+	}
+	for _, a := range t.Alerts {
+		n += 160 + len(a.Message) + 48*len(a.Details)
+	}
+	return int64(n)
+}
@@ -0,0 +1,360 @@
+package xrayhq
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func storeTrace(i int, start time.Time) *RequestTrace {
+	method := "GET"
+	if i%2 == 1 {
+		method = "POST"
+	}
+	return &RequestTrace{
+		ID:             fmt.Sprintf("t%d", i),
+		Method:         method,
+		Path:           "/items",
+		RoutePattern:   "/items",
+		ResponseStatus: 200 + i%3*100,
+		StartTime:      start.Add(time.Duration(i) * time.Second),
+		RequestBody:    []byte(`{"n":1}`),
+	}
+}
+
+func collectIDs(t *testing.T, s Store, f TraceFilter) []string {
+	t.Helper()
+	var ids []string
+	if err := s.Iterate(f, func(tr *RequestTrace) bool {
+		ids = append(ids, tr.ID)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+// testStore checks the behaviour every Store must share.
+func testStore(t *testing.T, s Store) {
+	start := time.Unix(1700000000, 0)
+	for i := 0; i < 6; i++ {
+		if err := s.Append(storeTrace(i, start)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.Len() != 6 {
+		t.Fatalf("expected 6 traces, got %d", s.Len())
+	}
+
+	got, err := s.Get("t3")
+	if err != nil || got == nil || got.Method != "POST" || string(got.RequestBody) != `{"n":1}` {
+		t.Fatalf("unexpected Get result %+v, %v", got, err)
+	}
+	if got, err := s.Get("missing"); got != nil || err != nil {
+		t.Errorf("expected nil for a missing ID, got %v, %v", got, err)
+	}
+
+	if ids := fmt.Sprint(collectIDs(t, s, TraceFilter{})); ids != "[t5 t4 t3 t2 t1 t0]" {
+		t.Errorf("expected newest first, got %s", ids)
+	}
+	if ids := fmt.Sprint(collectIDs(t, s, TraceFilter{Method: "POST", MinStatus: 300})); ids != "[t5 t1]" {
+		t.Errorf("unexpected filtered traces %s", ids)
+	}
+	if ids := fmt.Sprint(collectIDs(t, s, TraceFilter{Since: start.Add(2 * time.Second), Until: start.Add(4 * time.Second)})); ids != "[t3 t2]" {
+		t.Errorf("unexpected time range %s", ids)
+	}
+
+	n, err := s.Evict(start.Add(2 * time.Second))
+	if err != nil || n != 2 || s.Len() != 4 {
+		t.Errorf("expected 2 traces evicted, got %d (%v), %d left", n, err, s.Len())
+	}
+	if got, _ := s.Get("t0"); got != nil {
+		t.Error("expected evicted trace to be gone")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore(10))
+
+	s := NewMemoryStore(3)
+	for i := 0; i < 5; i++ {
+		s.Append(storeTrace(i, time.Now()))
+	}
+	if ids := fmt.Sprint(collectIDs(t, s, TraceFilter{})); ids != "[t4 t3 t2]" {
+		t.Errorf("expected ring to keep the newest 3, got %s", ids)
+	}
+}
+
+func TestDiskStore(t *testing.T) {
+	s, err := NewDiskStore(t.TempDir(), DiskStoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	testStore(t, s)
+}
+
+func TestDiskStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewDiskStore(dir, DiskStoreOptions{SegmentBytes: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		s.Append(storeTrace(i, start))
+	}
+	panicking := &RequestTrace{ID: "boom", Panicked: true, PanicValue: errors.New("nil map"), StartTime: start.Add(time.Minute)}
+	s.Append(panicking)
+	s.Close()
+
+	// Simulate a crash in the middle of writing a trace.
+	segments, _ := filepath.Glob(filepath.Join(dir, "segment-*.ndjson"))
+	if len(segments) < 2 {
+		t.Fatalf("expected several segments, got %d", len(segments))
+	}
+	f, _ := os.OpenFile(segments[len(segments)-1], os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString(`{"ID":"partial","Meth`)
+	f.Close()
+
+	s, err = NewDiskStore(dir, DiskStoreOptions{SegmentBytes: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.Len() != 21 {
+		t.Errorf("expected 21 traces after reopening, got %d", s.Len())
+	}
+	got, _ := s.Get("boom")
+	if got == nil || got.PanicValue != "nil map" {
+		t.Errorf("expected panic value stored as text, got %+v", got)
+	}
+	if err := s.Append(storeTrace(99, start)); err != nil {
+		t.Fatal(err)
+	}
+	if ids := collectIDs(t, s, TraceFilter{}); ids[0] != "t99" || ids[1] != "boom" {
+		t.Errorf("expected appends after the cut-off partial line, got %v", ids[:2])
+	}
+}
+
+func TestDiskStoreFailedWrite(t *testing.T) {
+	s, err := NewDiskStore(t.TempDir(), DiskStoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	start := time.Now()
+	s.Append(storeTrace(0, start))
+	s.Append(storeTrace(1, start))
+
+	// A write that only got part of the line out.
+	seg := s.segments[len(s.segments)-1]
+	f, _ := os.OpenFile(seg.path, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString(`{"ID":"half`)
+	f.Close()
+	s.discardPartialWrite(seg)
+	if err := s.Append(storeTrace(2, start)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A segment that can no longer be written or truncated.
+	ro, err := os.Open(seg.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seg.file.Close()
+	seg.file = ro
+	if err := s.Append(storeTrace(3, start)); err == nil {
+		t.Fatal("expected the write to fail")
+	}
+	if err := s.Append(storeTrace(4, start)); err != nil {
+		t.Fatalf("expected writing to move to a new segment, got %v", err)
+	}
+	if len(s.segments) != 2 {
+		t.Errorf("expected 2 segments, got %d", len(s.segments))
+	}
+
+	for _, id := range []string{"t0", "t1", "t2", "t4"} {
+		if tr, err := s.Get(id); err != nil || tr == nil || tr.ID != id {
+			t.Errorf("Get(%s) = %v, %v", id, tr, err)
+		}
+	}
+}
+
+func TestDiskStoreRetention(t *testing.T) {
+	s, err := NewDiskStore(t.TempDir(), DiskStoreOptions{SegmentBytes: 1024, MaxBytes: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	start := time.Now()
+	for i := 0; i < 200; i++ {
+		s.Append(storeTrace(i, start))
+	}
+	if s.Size() > 4096+1024 {
+		t.Errorf("expected size near MaxBytes, got %d", s.Size())
+	}
+	if got, _ := s.Get("t0"); got != nil {
+		t.Error("expected the oldest traces to be deleted")
+	}
+	if got, _ := s.Get("t199"); got == nil {
+		t.Error("expected the newest trace to be kept")
+	}
+
+	now := start
+	s.now = func() time.Time { return now }
+	s.opts.MaxAge = time.Minute
+	now = start.Add(time.Hour)
+	s.Append(storeTrace(300, now))
+	if s.Len() != 1 {
+		t.Errorf("expected aged segments deleted, %d traces left", s.Len())
+	}
+}
+
+func TestDiskStoreExpiresWhileIdle(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewDiskStore(dir, DiskStoreOptions{MaxAge: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Append(storeTrace(0, time.Now()))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s.Len() != 0 || s.Size() != 0 {
+		t.Errorf("expected the idle store to expire its traces, %d left in %d bytes", s.Len(), s.Size())
+	}
+	if segments, _ := filepath.Glob(filepath.Join(dir, "segment-*.ndjson")); len(segments) != 1 {
+		t.Errorf("expected only the empty current segment left, got %d", len(segments))
+	}
+}
+
+func TestCollectorWithDiskStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewDiskStore(dir, DiskStoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	cfg.Store = store
+	c := NewCollector(cfg)
+
+	c.Record(&RequestTrace{ID: "a", Method: "GET", RoutePattern: "/x", ResponseStatus: 200, StartTime: time.Now()})
+	c.Record(&RequestTrace{ID: "b", Method: "GET", RoutePattern: "/y", ResponseStatus: 200, StartTime: time.Now()})
+	if got := c.GetRequestsForRoute("GET", "/y", 10); len(got) != 1 || got[0].ID != "b" {
+		t.Errorf("unexpected route requests %v", got)
+	}
+	if err := c.Shutdown(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewDiskStore(dir, DiskStoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	cfg.Store = store
+	c = NewCollector(cfg)
+	if c.RequestCount() != 2 || c.GetRequestByID("a") == nil {
+		t.Error("expected traces to survive a restart")
+	}
+	if rm := c.GetRoute("GET", "/x"); rm == nil || rm.TotalRequests != 1 || c.RouteCount() != 2 {
+		t.Errorf("expected route metrics rebuilt from the store, got %+v", rm)
+	}
+}
+
+func TestMemoryStoreByteBudget(t *testing.T) {
+	big := func(id string) *RequestTrace {
+		return &RequestTrace{ID: id, RequestBody: make([]byte, 4000), ResponseBody: make([]byte, 4000)}
+	}
+
+	s := NewMemoryStoreWithOptions(MemoryStoreOptions{MaxBytes: 20000}).(*memoryStore)
+	for i := 0; i < 5; i++ {
+		s.Append(big(fmt.Sprint(i)))
+	}
+	if bytes, _ := s.Usage(); bytes > 20000 || s.Len() != 2 {
+		t.Errorf("expected oldest traces evicted to fit the budget, got %d traces in %d bytes", s.Len(), bytes)
+	}
+
+	s = NewMemoryStoreWithOptions(MemoryStoreOptions{MaxBytes: 20000, ShedBodies: true}).(*memoryStore)
+	first := big("first")
+	for _, tr := range []*RequestTrace{first, big("1"), big("2"), big("3")} {
+		s.Append(tr)
+	}
+	if s.Len() != 4 {
+		t.Errorf("expected bodies shed instead of evicting traces, got %d traces", s.Len())
+	}
+	shed, _ := s.Get("first")
+	if !shed.BodiesShed || shed.RequestBody != nil || first.RequestBody == nil {
+		t.Error("expected a stripped copy stored and the original left intact")
+	}
+	if newest, _ := s.Get("3"); newest.BodiesShed {
+		t.Error("expected the newest trace to keep its bodies")
+	}
+
+	for i := 0; i < 200; i++ {
+		s.Append(&RequestTrace{ID: fmt.Sprint("small", i)})
+	}
+	if bytes, budget := s.Usage(); bytes > budget {
+		t.Errorf("expected usage %d within budget %d", bytes, budget)
+	}
+	if got, _ := s.Get("first"); got != nil {
+		t.Error("expected whole traces evicted once there are no bodies left to shed")
+	}
+}
+
+func TestMemoryStoreIndexesAfterEviction(t *testing.T) {
+	s := NewMemoryStore(700)
+	start := time.Unix(1700000000, 0)
+	routes := []string{"/a", "/b", "/c"}
+	var all []*RequestTrace
+	for i := 0; i < 2000; i++ {
+		tr := &RequestTrace{
+			ID:             fmt.Sprint("t", i),
+			Method:         "GET",
+			RoutePattern:   routes[i%len(routes)],
+			ResponseStatus: []int{200, 404, 500, 201}[i%4],
+			// Finish order differs slightly from start order.
+			StartTime: start.Add(time.Duration(i-i%5) * time.Second),
+		}
+		s.Append(tr)
+		all = append(all, tr)
+	}
+	kept := all[len(all)-700:]
+
+	if got, _ := s.Get("t1299"); got != nil {
+		t.Error("expected evicted trace to be dropped from the ID index")
+	}
+	if got, _ := s.Get("t1300"); got == nil {
+		t.Error("expected oldest kept trace in the ID index")
+	}
+
+	filters := []TraceFilter{
+		{},
+		{Method: "GET", RoutePattern: "/b"},
+		{RoutePattern: "/c"},
+		{MinStatus: 400, MaxStatus: 499},
+		{MinStatus: 500},
+		{RoutePattern: "/a", MinStatus: 500},
+		{Since: start.Add(1900 * time.Second)},
+		{Since: start.Add(1500 * time.Second), Until: start.Add(1600 * time.Second), MaxStatus: 299},
+	}
+	for _, f := range filters {
+		var want []string
+		for i := len(kept) - 1; i >= 0; i-- {
+			if f.Match(kept[i]) {
+				want = append(want, kept[i].ID)
+			}
+		}
+		if got := collectIDs(t, s, f); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("filter %+v: got %d traces, want %d", f, len(got), len(want))
+		}
+	}
+}
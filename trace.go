@@ -1,6 +1,8 @@
 package xrayhq
 
 import (
+	"maps"
+	"slices"
 	"time"
 )
 
@@ -13,11 +15,16 @@ const (
 )
 
 type RequestTrace struct {
-	ID            string
-	Method        string
-	Path          string
-	RoutePattern  string
-	QueryParams   string
+	ID              string
+	TraceID         string // W3C trace-id, continued from the caller when present
+	SpanID          string // W3C span-id of this request
+	ParentSpanID    string // caller's span-id from the inbound traceparent
+	TraceFlags      byte
+	TraceState      string
+	Method          string
+	Path            string
+	RoutePattern    string
+	QueryParams     string
 	RequestHeaders  map[string]string
 	RequestBody     []byte
 	ResponseStatus  int
@@ -28,16 +35,31 @@ type RequestTrace struct {
 	ClientIP        string
 	UserAgent       string
 
-	StartTime     time.Time
-	EndTime       time.Time
-	Latency       time.Duration
-	TTFB          time.Duration
-	HandlerTime   time.Duration
+	// RequestBodyTruncated and ResponseBodyTruncated are set when a body was
+	// longer than Config.MaxBodyCaptureBytes and only its start was kept.
+	RequestBodyTruncated  bool
+	ResponseBodyTruncated bool
+	// BodiesShed is set when both bodies were dropped after capture to keep
+	// the trace buffer within Config.MaxBufferBytes.
+	BodiesShed bool
 
-	GoroutinesBefore int
-	GoroutinesAfter  int
-	MemAllocBefore   uint64
-	MemAllocAfter    uint64
+	StartTime   time.Time
+	EndTime     time.Time
+	Latency     time.Duration
+	TTFB        time.Duration
+	HandlerTime time.Duration
+
+	// Runtime counters around the request, set only when RuntimeSampled.
+	// MemAllocBefore and MemAllocAfter are process-wide cumulative heap
+	// allocations, so their delta includes other requests running at the
+	// same time. ConcurrentRequests is how many traced requests overlapped
+	// this one, including itself.
+	RuntimeSampled     bool
+	GoroutinesBefore   int
+	GoroutinesAfter    int
+	MemAllocBefore     uint64
+	MemAllocAfter      uint64
+	ConcurrentRequests int
 
 	DBQueries      []DBQuery
 	TotalDBTime    time.Duration
@@ -48,62 +70,120 @@ type RequestTrace struct {
 	MongoOps       []MongoOp
 	TotalMongoTime time.Duration
 
+	Spans []*Span
+
 	Panicked   bool
 	PanicValue interface{}
 	PanicStack string
 
 	Alerts []Alert
+
+	// SampleReason records why the trace was kept: "sampled" for the regular
+	// sampling rate, or "error", "panic", "alert" or "slow" when tail sampling
+	// kept it regardless of the rate.
+	SampleReason string
+	// SampleRate is the probability with which this request was kept, across
+	// head, tail, rule and adaptive sampling. A trace with SampleRate 0.1
+	// stands for about ten requests.
+	SampleRate float64
+
+	// ruleRate overrides SamplingRate for tail sampling when a RuleSample
+	// rule matched the request.
+	ruleRate    float64
+	ruleRateSet bool
+}
+
+// clone returns a copy of t that shares no mutable state with it. Copied
+// spans are detached, so their methods do nothing.
+func (t *RequestTrace) clone() *RequestTrace {
+	cp := *t
+	cp.RequestHeaders = maps.Clone(t.RequestHeaders)
+	cp.ResponseHeaders = maps.Clone(t.ResponseHeaders)
+	cp.RequestBody = slices.Clone(t.RequestBody)
+	cp.ResponseBody = slices.Clone(t.ResponseBody)
+	cp.DBQueries = slices.Clone(t.DBQueries)
+	cp.ExternalCalls = slices.Clone(t.ExternalCalls)
+	cp.RedisOps = slices.Clone(t.RedisOps)
+	cp.MongoOps = slices.Clone(t.MongoOps)
+	cp.Alerts = slices.Clone(t.Alerts)
+	if t.Spans != nil {
+		cp.Spans = make([]*Span, len(t.Spans))
+		for i, s := range t.Spans {
+			sc := *s
+			sc.Attrs = maps.Clone(s.Attrs)
+			sc.rec = nil
+			cp.Spans[i] = &sc
+		}
+	}
+	return &cp
+}
+
+// Truncated reports whether either captured body was cut short.
+func (t *RequestTrace) Truncated() bool {
+	return t.RequestBodyTruncated || t.ResponseBodyTruncated
 }
 
 type DBQuery struct {
-	Query       string
-	Duration    time.Duration
+	Query        string
+	Duration     time.Duration
 	RowsAffected int64
-	Error       string
-	Timestamp   time.Time
+	Error        string
+	Timestamp    time.Time
+	ParentSpanID string
 }
 
 type ExternalCall struct {
-	URL        string
-	Method     string
-	StatusCode int
-	Duration   time.Duration
-	Error      string
-	Timestamp  time.Time
+	URL          string
+	Method       string
+	StatusCode   int
+	Duration     time.Duration
+	Error        string
+	Timestamp    time.Time
+	ParentSpanID string
+	SpanID       string // span-id propagated to the callee in traceparent
 }
 
 type RedisOp struct {
-	Command   string
-	Key       string
-	Duration  time.Duration
-	Error     string
-	Timestamp time.Time
+	Command      string
+	Key          string
+	Duration     time.Duration
+	Error        string
+	Timestamp    time.Time
+	ParentSpanID string
 }
 
 type MongoOp struct {
-	Collection string
-	Operation  string
-	Filter     string
-	Duration   time.Duration
-	Error      string
-	Timestamp  time.Time
+	Collection   string
+	Operation    string
+	Filter       string
+	Duration     time.Duration
+	Error        string
+	Timestamp    time.Time
+	ParentSpanID string
 }
 
 type Alert struct {
-	ID        string
-	Type      string
-	Message   string
-	Severity  Severity
+	ID           string
+	Type         string
+	Message      string
+	Severity     Severity
+	Method       string
 	RoutePattern string
 	RequestID    string
 	Timestamp    time.Time
 	Details      map[string]interface{}
+
+	// Pattern is the normalized query for query alerts. Together with Type,
+	// Method and RoutePattern it makes up the Fingerprint alerts are grouped
+	// by.
+	Pattern     string
+	Fingerprint string
 }
 
 type DBPoolStats struct {
-	OpenConnections int
-	IdleConnections int
+	OpenConnections  int
+	IdleConnections  int
 	InUseConnections int
-	WaitCount       int64
-	WaitDuration    time.Duration
+	WaitCount        int64
+	WaitDuration     time.Duration
 }
@@ -0,0 +1,106 @@
+package xrayhq
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// W3C Trace Context headers (https://www.w3.org/TR/trace-context/).
+const (
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+)
+
+// traceFlagSampled is the sampled bit of the traceparent trace-flags.
+const traceFlagSampled byte = 0x01
+
+// applyTraceContext continues the caller's trace from the inbound
+// traceparent and tracestate headers, or starts a new trace when they are
+// missing or malformed. The request always gets its own span ID. The sampled
+// flag reflects xrayhq's own decision, so downstream services are only told
+// the request is sampled when it is going to be kept; other flags from the
+// caller are passed on.
+func applyTraceContext(t *RequestTrace, traceparent, tracestate string, sampled bool) {
+	t.SpanID = generateSpanID()
+	if traceID, parentID, flags, ok := parseTraceparent(traceparent); ok {
+		t.TraceID = traceID
+		t.ParentSpanID = parentID
+		t.TraceFlags = flags &^ traceFlagSampled
+		t.TraceState = tracestate
+	} else {
+		t.TraceID = generateID()
+	}
+	if sampled {
+		t.TraceFlags |= traceFlagSampled
+	}
+}
+
+// injectTraceContext sets traceparent and tracestate on an outbound request
+// so the callee continues this trace as a child of spanID.
+func injectTraceContext(h http.Header, t *RequestTrace, spanID string) {
+	h.Set(traceparentHeader, formatTraceparent(t.TraceID, spanID, t.TraceFlags))
+	if t.TraceState != "" {
+		h.Set(tracestateHeader, t.TraceState)
+	}
+}
+
+func formatTraceparent(traceID, spanID string, flags byte) string {
+	return fmt.Sprintf("00-%s-%s-%02x", traceID, spanID, flags)
+}
+
+// parseTraceparent parses a version-00 traceparent header. Future versions
+// are accepted as long as they start with the version-00 fields, as the
+// specification requires.
+func parseTraceparent(h string) (traceID, spanID string, flags byte, ok bool) {
+	if len(h) < 55 || h[2] != '-' || h[35] != '-' || h[52] != '-' {
+		return "", "", 0, false
+	}
+	version := h[0:2]
+	if !isLowerHex(version) || version == "ff" {
+		return "", "", 0, false
+	}
+	if version == "00" && len(h) != 55 {
+		return "", "", 0, false
+	}
+	if len(h) > 55 && h[55] != '-' {
+		return "", "", 0, false
+	}
+	traceID, spanID = h[3:35], h[36:52]
+	if !isLowerHex(traceID) || !isLowerHex(spanID) || !isLowerHex(h[53:55]) {
+		return "", "", 0, false
+	}
+	if isAllZeros(traceID) || isAllZeros(spanID) {
+		return "", "", 0, false
+	}
+	var f byte
+	for i := 53; i < 55; i++ {
+		f = f<<4 | hexValue(h[i])
+	}
+	return traceID, spanID, f, true
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func isAllZeros(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] != '0' {
+			return false
+		}
+	}
+	return true
+}
+
+func hexValue(c byte) byte {
+	if c <= '9' {
+		return c - '0'
+	}
+	return c - 'a' + 10
+}
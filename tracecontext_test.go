@@ -0,0 +1,128 @@
+package xrayhq
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	traceID, spanID, flags, ok := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok {
+		t.Fatal("expected valid traceparent")
+	}
+	if traceID != "4bf92f3577b34da6a3ce929d0e0e4736" || spanID != "00f067aa0ba902b7" || flags != 0x01 {
+		t.Errorf("unexpected parse result %s %s %02x", traceID, spanID, flags)
+	}
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	}
+	for _, h := range invalid {
+		if _, _, _, ok := parseTraceparent(h); ok {
+			t.Errorf("expected %q to be rejected", h)
+		}
+	}
+
+	if _, _, _, ok := parseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future"); !ok {
+		t.Error("expected future version with extra fields to be accepted")
+	}
+}
+
+func TestTraceContextPropagation(t *testing.T) {
+	c, cfg := setupTestCollector()
+
+	var downstream http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downstream = r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	client := WrapHTTPClient(upstream.Client())
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequestWithContext(r.Context(), "GET", upstream.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Errorf("outbound call failed: %v", err)
+			return
+		}
+		resp.Body.Close()
+	})
+
+	wrapped := coreMiddleware(c, cfg, handler)
+	req := httptest.NewRequest("GET", "/checkout", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("tracestate", "vendor=abc")
+	wrapped.ServeHTTP(httptest.NewRecorder(), req)
+
+	trace := c.GetRecentRequests(1)[0]
+	if trace.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected inbound trace ID, got %s", trace.TraceID)
+	}
+	if trace.ParentSpanID != "00f067aa0ba902b7" {
+		t.Errorf("expected inbound parent span ID, got %s", trace.ParentSpanID)
+	}
+	if len(trace.SpanID) != 16 || trace.SpanID == trace.ParentSpanID {
+		t.Errorf("expected a new span ID for the request, got %s", trace.SpanID)
+	}
+
+	call := trace.ExternalCalls[0]
+	want := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + call.SpanID + "-01"
+	if got := downstream.Get("traceparent"); got != want {
+		t.Errorf("expected outbound traceparent %s, got %s", want, got)
+	}
+	if got := downstream.Get("tracestate"); got != "vendor=abc" {
+		t.Errorf("expected tracestate to be forwarded, got %q", got)
+	}
+}
+
+func TestTraceContextStartsNewTrace(t *testing.T) {
+	c, cfg := setupTestCollector()
+	wrapped := coreMiddleware(c, cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("traceparent", "garbage")
+	wrapped.ServeHTTP(httptest.NewRecorder(), req)
+
+	trace := c.GetRecentRequests(1)[0]
+	if len(trace.TraceID) != 32 || trace.ParentSpanID != "" || trace.TraceFlags != traceFlagSampled {
+		t.Errorf("expected a fresh sampled trace, got %+v", trace)
+	}
+}
+
+func TestTraceFlagsFollowSamplingDecision(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        SamplingMode
+		rules       []SamplingRule
+		traceparent string
+		want        byte
+	}{
+		{"head new trace", SamplingHead, nil, "", 0x01},
+		{"head marks caller trace sampled", SamplingHead, nil, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", 0x01},
+		{"tail new trace", SamplingTail, nil, "", 0x00},
+		{"tail overrides caller", SamplingTail, nil, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-03", 0x02},
+		{"tail capture rule", SamplingTail, []SamplingRule{{Path: "/*", Action: RuleCapture}}, "", 0x01},
+		{"head route rule pending", SamplingHead, []SamplingRule{{Route: "/*", Action: RuleSample, Rate: 1}}, "", 0x00},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.SamplingMode = tt.mode
+			cfg.SamplingRules = tt.rules
+			plan := planSampling(cfg, "GET", "/x", func(string) string { return "" })
+
+			tr := &RequestTrace{}
+			applyTraceContext(tr, tt.traceparent, "", plan.sampled())
+			if tr.TraceFlags != tt.want {
+				t.Errorf("expected flags %02x, got %02x", tt.want, tr.TraceFlags)
+			}
+		})
+	}
+}
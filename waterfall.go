@@ -0,0 +1,139 @@
+package xrayhq
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// waterfallRow is one line of the request detail waterfall. Rows are
+// produced in tree order, so Depth is enough to render the hierarchy.
+type waterfallRow struct {
+	Kind     string // handler, span, db, ext, redis or mongo
+	Label    string
+	Title    string
+	Depth    int
+	Start    time.Time
+	Duration time.Duration
+	SelfTime time.Duration
+	Error    string
+	Attrs    map[string]interface{}
+}
+
+type waterfallNode struct {
+	row      waterfallRow
+	id       string
+	children []*waterfallNode
+}
+
+// buildWaterfall arranges the request, its spans and its operations into a
+// tree and flattens it depth-first. Self time is the part of a node's
+// duration not covered by any of its direct children, so children that ran
+// in parallel are only counted once.
+func buildWaterfall(t *RequestTrace) []waterfallRow {
+	root := &waterfallNode{row: waterfallRow{
+		Kind:     "handler",
+		Label:    "Handler",
+		Title:    t.Method + " " + t.Path,
+		Start:    t.StartTime,
+		Duration: t.Latency,
+	}}
+
+	spans := make(map[string]*waterfallNode, len(t.Spans))
+	for _, s := range t.Spans {
+		spans[s.ID] = &waterfallNode{id: s.ID, row: waterfallRow{
+			Kind:     "span",
+			Label:    s.Name,
+			Title:    s.Name,
+			Start:    s.StartTime,
+			Duration: s.Duration,
+			Error:    s.Error,
+			Attrs:    s.Attrs,
+		}}
+	}
+	parentOf := func(id string) *waterfallNode {
+		if n, ok := spans[id]; ok {
+			return n
+		}
+		return root
+	}
+	for _, s := range t.Spans {
+		p := parentOf(s.ParentID)
+		p.children = append(p.children, spans[s.ID])
+	}
+
+	for _, q := range t.DBQueries {
+		p := parentOf(q.ParentSpanID)
+		p.children = append(p.children, &waterfallNode{row: waterfallRow{
+			Kind: "db", Label: "DB: " + truncate(q.Query, 40), Title: q.Query,
+			Start: q.Timestamp, Duration: q.Duration, Error: q.Error,
+		}})
+	}
+	for _, c := range t.ExternalCalls {
+		p := parentOf(c.ParentSpanID)
+		p.children = append(p.children, &waterfallNode{row: waterfallRow{
+			Kind: "ext", Label: "HTTP: " + truncate(c.URL, 40), Title: c.Method + " " + c.URL,
+			Start: c.Timestamp, Duration: c.Duration, Error: c.Error,
+		}})
+	}
+	for _, op := range t.RedisOps {
+		p := parentOf(op.ParentSpanID)
+		label := fmt.Sprintf("Redis: %s %s", op.Command, op.Key)
+		p.children = append(p.children, &waterfallNode{row: waterfallRow{
+			Kind: "redis", Label: label, Title: label,
+			Start: op.Timestamp, Duration: op.Duration, Error: op.Error,
+		}})
+	}
+	for _, op := range t.MongoOps {
+		p := parentOf(op.ParentSpanID)
+		label := fmt.Sprintf("Mongo: %s %s", op.Operation, op.Collection)
+		p.children = append(p.children, &waterfallNode{row: waterfallRow{
+			Kind: "mongo", Label: label, Title: label + " " + op.Filter,
+			Start: op.Timestamp, Duration: op.Duration, Error: op.Error,
+		}})
+	}
+
+	rows := make([]waterfallRow, 0, 1+len(t.Spans)+len(t.DBQueries)+len(t.ExternalCalls)+len(t.RedisOps)+len(t.MongoOps))
+	var walk func(n *waterfallNode, depth int)
+	walk = func(n *waterfallNode, depth int) {
+		sort.SliceStable(n.children, func(i, j int) bool {
+			return n.children[i].row.Start.Before(n.children[j].row.Start)
+		})
+		n.row.Depth = depth
+		n.row.SelfTime = n.row.Duration - childCoverage(n)
+		rows = append(rows, n.row)
+		for _, c := range n.children {
+			walk(c, depth+1)
+		}
+	}
+	walk(root, 0)
+	return rows
+}
+
+// childCoverage returns how much of n's duration is covered by the union of
+// its children's intervals. The children must be sorted by start time.
+func childCoverage(n *waterfallNode) time.Duration {
+	start, end := n.row.Start, n.row.Start.Add(n.row.Duration)
+	var covered time.Duration
+	var curStart, curEnd time.Time
+	for _, c := range n.children {
+		s := c.row.Start
+		if s.Before(start) {
+			s = start
+		}
+		e := c.row.Start.Add(c.row.Duration)
+		if e.After(end) {
+			e = end
+		}
+		if !e.After(s) {
+			continue
+		}
+		if s.After(curEnd) {
+			covered += curEnd.Sub(curStart)
+			curStart, curEnd = s, e
+		} else if e.After(curEnd) {
+			curEnd = e
+		}
+	}
+	return covered + curEnd.Sub(curStart)
+}
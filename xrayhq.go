@@ -12,7 +12,9 @@
 // # Quick Start
 //
 //	func main() {
-//	    xrayhq.Init(xrayhq.WithPort(":9090"))
+//	    if _, err := xrayhq.Init(xrayhq.WithPort(":9090")); err != nil {
+//	        log.Fatal(err)
+//	    }
 //	    handler := xrayhq.Wrap(yourMux)
 //	    http.ListenAndServe(":8080", handler)
 //	}
@@ -26,38 +28,204 @@
 package xrayhq
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"sync"
 )
 
-var defaultCollector *Collector
-var defaultConfig *Config
+// Instance is an isolated xrayhq setup with its own configuration,
+// collector and dashboard. Use New to run several instances side by side,
+// for example one for an admin API and one for a public API, or one per
+// parallel test. The package-level functions use a default instance
+// created by Init.
+type Instance struct {
+	config    *Config
+	collector *Collector
 
-// Init initializes xrayhq with the given options and starts the dashboard server.
-func Init(opts ...Option) {
+	dashboardOnce sync.Once
+	dashboard     http.Handler
+
+	mu     sync.Mutex
+	server *http.Server
+	addr   net.Addr
+}
+
+// New creates an instance with the given options, or returns an error if the
+// resulting config is invalid. Unlike Init it does not start a dashboard
+// server; call Start, or mount DashboardHandler wherever it should be served.
+func New(opts ...Option) (*Instance, error) {
 	cfg := DefaultConfig()
 	for _, o := range opts {
 		o(cfg)
 	}
-	defaultConfig = cfg
-	defaultCollector = NewCollector(cfg)
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &Instance{
+		config:    cfg,
+		collector: NewCollector(cfg),
+	}, nil
+}
+
+// Middleware wraps an http.Handler with this instance's request tracing.
+func (i *Instance) Middleware(next http.Handler) http.Handler {
+	return coreMiddleware(i.collector, i.config, next)
+}
+
+// DashboardHandler returns an http.Handler serving this instance's
+// dashboard, protected by basic auth when it is configured.
+func (i *Instance) DashboardHandler() http.Handler {
+	i.dashboardOnce.Do(func() {
+		i.dashboard = newDashboardHandler(i.collector, i.config)
+	})
+	return i.dashboard
+}
+
+// MetricsHandler returns an http.Handler serving this instance's metrics in
+// the Prometheus text format, for mounting on your own mux. The dashboard
+// also serves it at /metrics.
+func (i *Instance) MetricsHandler() http.Handler {
+	return i.collector.MetricsHandler()
+}
+
+// Collector returns the instance's collector.
+func (i *Instance) Collector() *Collector {
+	return i.collector
+}
 
-	// Start dashboard server in a separate goroutine
+// Config returns the instance's config.
+func (i *Instance) Config() *Config {
+	return i.config
+}
+
+// Start serves the dashboard on the configured port in the background. It
+// returns an error if the port cannot be bound or the dashboard is already
+// running.
+func (i *Instance) Start() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.server != nil {
+		return errors.New("xrayhq: dashboard already started")
+	}
+
+	ln, err := net.Listen("tcp", i.config.Port)
+	if err != nil {
+		return fmt.Errorf("xrayhq: dashboard: %w", err)
+	}
+	srv := &http.Server{Handler: i.DashboardHandler()}
+	i.server = srv
+	i.addr = ln.Addr()
+
+	log.Printf("[xrayhq] Dashboard available at http://%s\n", dashboardHost(ln.Addr()))
 	go func() {
-		srv := NewDashboardServer(defaultCollector, cfg)
-		log.Printf("[xrayhq] Dashboard available at http://localhost%s\n", cfg.Port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
 			log.Printf("[xrayhq] Dashboard server error: %v\n", err)
 		}
 	}()
+	return nil
+}
+
+// DashboardAddr returns the address the dashboard is listening on, or "" if
+// Start has not been called. It is useful with a port of ":0".
+func (i *Instance) DashboardAddr() string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.addr == nil {
+		return ""
+	}
+	return i.addr.String()
+}
+
+// Shutdown closes SSE subscribers, stops the dashboard server if it was
+// started and flushes traces queued for export. Requests recorded afterwards
+// still update the collector but are no longer exported.
+func (i *Instance) Shutdown(ctx context.Context) error {
+	i.collector.closeSSE()
+
+	i.mu.Lock()
+	srv := i.server
+	i.mu.Unlock()
+
+	var errs []error
+	if srv != nil {
+		if err := srv.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := i.collector.Shutdown(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// dashboardHost formats a listener address for the startup log, showing
+// localhost for wildcard binds such as ":9090".
+func dashboardHost(addr net.Addr) string {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok || !tcp.IP.IsUnspecified() {
+		return addr.String()
+	}
+	return fmt.Sprintf("localhost:%d", tcp.Port)
+}
+
+var (
+	defaultMu       sync.Mutex
+	defaultInstance *Instance
+)
+
+// Init creates the default instance with the given options and starts its
+// dashboard server. It returns an error if the config is invalid or the
+// dashboard port cannot be bound; the default instance is left unchanged
+// in that case.
+func Init(opts ...Option) (*Instance, error) {
+	inst, err := New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := inst.Start(); err != nil {
+		return nil, err
+	}
+
+	defaultMu.Lock()
+	defaultInstance = inst
+	defaultMu.Unlock()
+	return inst, nil
+}
+
+// Default returns the default instance. If Init has not been called, it
+// creates one with the default options and tries to start its dashboard,
+// logging rather than failing if the port is taken.
+func Default() *Instance {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultInstance == nil {
+		inst, _ := New() // the default config is always valid
+		if err := inst.Start(); err != nil {
+			log.Printf("[xrayhq] %v\n", err)
+		}
+		defaultInstance = inst
+	}
+	return defaultInstance
+}
+
+// Shutdown shuts down the default instance, if there is one.
+func Shutdown(ctx context.Context) error {
+	defaultMu.Lock()
+	inst := defaultInstance
+	defaultMu.Unlock()
+	if inst == nil {
+		return nil
+	}
+	return inst.Shutdown(ctx)
 }
 
 // Wrap wraps an http.Handler with xrayhq middleware.
 func Wrap(handler http.Handler) http.Handler {
-	if defaultCollector == nil {
-		Init()
-	}
-	return coreMiddleware(defaultCollector, defaultConfig, handler)
+	return Default().Middleware(handler)
 }
 
 // WrapFunc wraps an http.HandlerFunc with xrayhq middleware.
@@ -65,20 +233,32 @@ func WrapFunc(handler http.HandlerFunc) http.Handler {
 	return Wrap(handler)
 }
 
-// GetCollector returns the default collector (for advanced usage).
+// GetCollector returns the default collector (for advanced usage), or nil
+// if Init has not been called.
 func GetCollector() *Collector {
-	return defaultCollector
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultInstance == nil {
+		return nil
+	}
+	return defaultInstance.collector
 }
 
-// GetConfig returns the default config (for advanced usage).
+// GetConfig returns the default config (for advanced usage), or nil if Init
+// has not been called.
 func GetConfig() *Config {
-	return defaultConfig
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultInstance == nil {
+		return nil
+	}
+	return defaultInstance.config
 }
 
 // SetRoutePattern sets the route pattern on the trace in context.
 // Framework adapters call this to record the matched route pattern.
 func SetRoutePattern(r *http.Request, pattern string) {
-	if t := TraceFromContext(r.Context()); t != nil {
-		t.RoutePattern = pattern
+	if rec := recorderFromContext(r.Context()); rec != nil {
+		rec.mutate(func(t *RequestTrace) { t.RoutePattern = pattern })
 	}
 }
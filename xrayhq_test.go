@@ -0,0 +1,130 @@
+package xrayhq
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInstancesAreIsolated(t *testing.T) {
+	admin, err := New(WithBasicAuth("admin", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	admin.Middleware(ok).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/admin/users", nil))
+	public.Middleware(ok).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/products", nil))
+	public.Middleware(ok).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/products", nil))
+
+	if admin.Collector().RequestCount() != 1 {
+		t.Errorf("expected 1 admin request, got %d", admin.Collector().RequestCount())
+	}
+	if public.Collector().RequestCount() != 2 {
+		t.Errorf("expected 2 public requests, got %d", public.Collector().RequestCount())
+	}
+	if admin.Collector().GetRoute("GET", "/products") != nil {
+		t.Error("public route leaked into admin instance")
+	}
+
+	// Each dashboard serves its own collector and auth settings.
+	rec := httptest.NewRecorder()
+	admin.DashboardHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected admin dashboard to require auth, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	public.DashboardHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 from public dashboard, got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "/products") || strings.Contains(body, "/admin/users") {
+		t.Error("expected public dashboard to list only public routes")
+	}
+}
+
+func TestNewRejectsInvalidConfig(t *testing.T) {
+	tests := map[string]Option{
+		"no buffer limit": func(c *Config) { c.BufferSize, c.MaxBufferBytes = 0, 0 },
+		"negative buffer": WithBufferSize(-5),
+		"negative budget": WithMaxBufferBytes(-1),
+		"negative routes": WithMaxRoutes(-1),
+		"sampling rate":   WithSamplingRate(1.5),
+		"half basic auth": WithBasicAuth("admin", ""),
+	}
+	for name, opt := range tests {
+		if _, err := New(opt); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestInstanceStartReportsPortInUse(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	x, err := New(WithPort(ln.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := x.Start(); err == nil {
+		t.Fatal("expected error when the dashboard port is taken")
+	}
+}
+
+func TestInstanceShutdown(t *testing.T) {
+	x, err := New(WithPort("127.0.0.1:0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := x.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.Start(); err == nil {
+		t.Error("expected error when starting twice")
+	}
+
+	// An open SSE stream never goes idle, so the server only shuts down in
+	// time if the subscription is closed first.
+	resp, err := http.Get("http://" + x.DashboardAddr() + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := x.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Errorf("expected SSE stream to end cleanly, got %v", err)
+	}
+	if _, err := http.Get("http://" + x.DashboardAddr() + "/"); err == nil {
+		t.Error("expected dashboard to be stopped")
+	}
+
+	// Subscribers that arrive after shutdown get a closed channel, and
+	// unsubscribing it must not panic.
+	ch := x.Collector().SubscribeSSE()
+	if _, ok := <-ch; ok {
+		t.Error("expected closed channel after shutdown")
+	}
+	x.Collector().UnsubscribeSSE(ch)
+}